@@ -0,0 +1,219 @@
+package avalanche
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// AuditKind is the kind of event an AuditEntry records
+type AuditKind string
+
+const (
+	// AuditVote is a vote received for the Target
+	AuditVote AuditKind = "vote"
+
+	// AuditConfidence is a change to the Target's confidence or acceptance
+	AuditConfidence AuditKind = "confidence"
+
+	// AuditStatus is a StatusUpdate for the Target
+	AuditStatus AuditKind = "status"
+)
+
+// AuditEntry is an event in the audit trail of a Target. Every entry records
+// the Response that caused it; the remaining fields depend on the Kind.
+type AuditEntry struct {
+	Kind  AuditKind
+	Time  time.Time
+	Hash  Hash
+	Node  NodeID
+	Round int64
+
+	// Vote is the vote received, for AuditVote entries
+	Vote uint32
+
+	// Implied is true for AuditVote entries that were not in the Response but
+	// implied by a yes vote for a descendant in the DAG
+	Implied bool
+
+	// Confidence and Accepted are the new state, for AuditConfidence entries
+	Confidence uint16
+	Accepted   bool
+
+	// Status is the new Status, for AuditStatus entries
+	Status Status
+}
+
+// MarshalJSON implements the json.Marshaler interface. Only the fields that
+// apply to the entry's Kind are included.
+func (e AuditEntry) MarshalJSON() ([]byte, error) {
+	type common struct {
+		Kind  AuditKind `json:"kind"`
+		Time  time.Time `json:"time"`
+		Hash  Hash      `json:"hash"`
+		Node  NodeID    `json:"node"`
+		Round int64     `json:"round"`
+	}
+	c := common{e.Kind, e.Time, e.Hash, e.Node, e.Round}
+
+	switch e.Kind {
+	case AuditVote:
+		return json.Marshal(struct {
+			common
+			Vote    uint32 `json:"vote"`
+			Implied bool   `json:"implied,omitempty"`
+		}{c, e.Vote, e.Implied})
+	case AuditConfidence:
+		return json.Marshal(struct {
+			common
+			Confidence uint16 `json:"confidence"`
+			Accepted   bool   `json:"accepted"`
+		}{c, e.Confidence, e.Accepted})
+	case AuditStatus:
+		return json.Marshal(struct {
+			common
+			Status string `json:"status"`
+		}{c, e.Status.String()})
+	}
+	return json.Marshal(c)
+}
+
+// GetAuditTrail returns the audit trail of the Target with the given hash,
+// oldest first. It is empty unless the Processor was created with
+// WithAuditTrail. Trails are kept until they are discarded with
+// ForgetAuditTrail or ForgetFinalized.
+func (p *Processor) GetAuditTrail(h Hash) []AuditEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]AuditEntry{}, p.audit[h]...)
+}
+
+// ForgetAuditTrail discards the audit trail of the Target with the given hash
+func (p *Processor) ForgetAuditTrail(h Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.audit, h)
+}
+
+// WriteAuditTrail writes the audit trails of the Targets with the given
+// hashes to w as JSON lines, one entry per line. If no hashes are given every
+// trail is written. Trails are written in order of hash.
+func (p *Processor) WriteAuditTrail(w io.Writer, hashes ...Hash) error {
+	p.mu.RLock()
+	if len(hashes) == 0 {
+		for h := range p.audit {
+			hashes = append(hashes, h)
+		}
+	} else {
+		hashes = append([]Hash{}, hashes...)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].Compare(hashes[j]) < 0 })
+
+	entries := []AuditEntry{}
+	for _, h := range hashes {
+		entries = append(entries, p.audit[h]...)
+	}
+	p.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditVote records a vote for a Target that is being voted on
+func (p *Processor) auditVote(id NodeID, round int64, v Vote, implied bool, now time.Time) {
+	if p.audit == nil {
+		return
+	}
+	p.appendAudit(AuditEntry{
+		Kind:    AuditVote,
+		Time:    now,
+		Hash:    v.GetHash(),
+		Node:    id,
+		Round:   round,
+		Vote:    v.GetError(),
+		Implied: implied,
+	})
+}
+
+// auditConfidence records the state of a VoteRecord if it differs from the
+// state before the vote
+func (p *Processor) auditConfidence(id NodeID, round int64, h Hash, before uint16, vr *VoteRecord,
+	now time.Time) {
+	if p.audit == nil || vr.confidence == before {
+		return
+	}
+	p.appendAudit(AuditEntry{
+		Kind:       AuditConfidence,
+		Time:       now,
+		Hash:       h,
+		Node:       id,
+		Round:      round,
+		Confidence: vr.getConfidence(),
+		Accepted:   vr.isAccepted(),
+	})
+}
+
+// auditUpdates records the StatusUpdates caused by a Response. Targets can
+// change without a vote for them; e.g. a member of a conflict set is rejected
+// when another member gains the preference, and is invalidated when another
+// member is finalized. The new state of such a Target is recorded before its
+// status.
+func (p *Processor) auditUpdates(id NodeID, round int64, updates []StatusUpdate) {
+	if p.audit == nil {
+		return
+	}
+
+	now := p.now()
+	for _, u := range updates {
+		if vr, ok := p.voteRecords[u.Hash]; ok && !p.auditedState(u.Hash, vr) {
+			p.appendAudit(AuditEntry{
+				Kind:       AuditConfidence,
+				Time:       now,
+				Hash:       u.Hash,
+				Node:       id,
+				Round:      round,
+				Confidence: vr.getConfidence(),
+				Accepted:   vr.isAccepted(),
+			})
+		}
+
+		p.appendAudit(AuditEntry{
+			Kind:   AuditStatus,
+			Time:   now,
+			Hash:   u.Hash,
+			Node:   id,
+			Round:  round,
+			Status: u.Status,
+		})
+	}
+}
+
+// auditedState returns whether or not the last AuditConfidence entry in the
+// Target's trail matches the state of its VoteRecord
+func (p *Processor) auditedState(h Hash, vr *VoteRecord) bool {
+	trail := p.audit[h]
+	for i := len(trail) - 1; i >= 0; i-- {
+		if trail[i].Kind == AuditConfidence {
+			return trail[i].Confidence == vr.getConfidence() && trail[i].Accepted == vr.isAccepted()
+		}
+	}
+	return false
+}
+
+// appendAudit adds an entry to a Target's audit trail, dropping the oldest
+// entries beyond the limit
+func (p *Processor) appendAudit(e AuditEntry) {
+	trail := append(p.audit[e.Hash], e)
+	if p.auditLimit > 0 && len(trail) > p.auditLimit {
+		trail = append(trail[:0:0], trail[len(trail)-p.auditLimit:]...)
+	}
+	p.audit[e.Hash] = trail
+}
@@ -0,0 +1,172 @@
+package avalanche
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditTrail(t *testing.T) {
+	defer func(c clocker) { clock = c }(clock)
+	now := time.Now()
+	clock = stubClocker{now}
+
+	var (
+		connman = NewConnman()
+		nodeID  = NodeID(0)
+		updates = []StatusUpdate{}
+
+		parent = &testTx{hash: Hash{1}, accepted: true}
+		child  = &testTx{hash: Hash{2}, parents: []Hash{parent.hash}}
+	)
+	connman.AddNode(nodeID)
+	p, err := NewProcessor(connman, DefaultConfig(), WithAuditTrail(0))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(parent))
+	assertTrue(t, p.AddTargetToReconcile(child))
+
+	// Vote yes for the child until it is accepted
+	round := p.GetRound()
+	for !p.IsAccepted(child) {
+		assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{child.hash: 0}, &updates))
+	}
+
+	trail := p.GetAuditTrail(child.hash)
+	assertTrue(t, trail[0] == AuditEntry{Kind: AuditVote, Time: clock.Now(), Hash: child.hash, Node: nodeID, Round: round})
+
+	votes, changes, statuses := 0, 0, 0
+	for _, e := range trail {
+		switch e.Kind {
+		case AuditVote:
+			votes++
+			assertFalse(t, e.Implied)
+		case AuditConfidence:
+			changes++
+			assertTrue(t, e.Accepted && e.Confidence == 0)
+		case AuditStatus:
+			statuses++
+			assertTrue(t, e.Status == StatusAccepted)
+		}
+	}
+	assertTrue(t, votes == int(p.GetRound()-round))
+	assertTrue(t, changes == 1 && statuses == 1)
+
+	// The child's votes count toward the parent
+	for _, e := range p.GetAuditTrail(parent.hash) {
+		if e.Kind == AuditVote {
+			assertTrue(t, e.Implied && e.Vote == 0)
+		}
+	}
+
+	// Trails are exported as JSON lines
+	buf := &bytes.Buffer{}
+	assertNoError(t, p.WriteAuditTrail(buf, child.hash))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertTrue(t, len(lines) == len(trail))
+
+	var last map[string]interface{}
+	assertNoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assertTrue(t, last["kind"] == "status" && last["status"] == "accepted")
+	assertTrue(t, last["hash"] == child.hash.String() && last["node"] == float64(nodeID))
+	_, hasVote := last["vote"]
+	assertFalse(t, hasVote)
+
+	buf.Reset()
+	assertNoError(t, p.WriteAuditTrail(buf))
+	assertTrue(t, strings.Count(buf.String(), "\n") == len(trail)+len(p.GetAuditTrail(parent.hash)))
+
+	p.ForgetAuditTrail(child.hash)
+	assertTrue(t, len(p.GetAuditTrail(child.hash)) == 0)
+}
+
+func TestAuditTrailConflicts(t *testing.T) {
+	var (
+		connman = NewConnman()
+		nodeID  = NodeID(0)
+		updates = []StatusUpdate{}
+
+		loser  = &testTx{hash: Hash{1}, accepted: true, conflicts: []string{"x"}}
+		winner = &testTx{hash: Hash{2}, conflicts: []string{"x"}}
+		child  = &testTx{hash: Hash{3}, accepted: true, parents: []Hash{loser.hash}}
+	)
+	connman.AddNode(nodeID)
+	p, err := NewProcessor(connman, DefaultConfig(), WithAuditTrail(0))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(loser))
+	assertTrue(t, p.AddTargetToReconcile(winner))
+	assertTrue(t, p.AddTargetToReconcile(child))
+
+	// Vote only for the winner until it is finalized
+	for {
+		if _, ok := p.GetFinalized(winner.hash); ok {
+			break
+		}
+		assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{winner.hash: 0}, &updates))
+	}
+
+	statuses := func(h Hash) []Status {
+		s := []Status{}
+		for _, e := range p.GetAuditTrail(h) {
+			if e.Kind == AuditStatus {
+				s = append(s, e.Status)
+			}
+		}
+		return s
+	}
+
+	// The loser is rejected when the winner takes the preference, which is
+	// recorded along with its new state, and invalidated once the winner is
+	// finalized
+	trail := p.GetAuditTrail(loser.hash)
+	rejected := -1
+	for i, e := range trail {
+		if e.Kind == AuditStatus && e.Status == StatusRejected {
+			rejected = i
+			break
+		}
+	}
+	assertTrue(t, rejected > 0)
+	previous := trail[rejected-1]
+	assertTrue(t, previous.Kind == AuditConfidence && !previous.Accepted && previous.Confidence == 0)
+	loserStatuses := statuses(loser.hash)
+	assertTrue(t, loserStatuses[len(loserStatuses)-1] == StatusInvalid)
+
+	// Anything built on the loser is invalidated with it
+	childStatuses := statuses(child.hash)
+	assertTrue(t, len(childStatuses) > 0 && childStatuses[len(childStatuses)-1] == StatusInvalid)
+
+	// Forgetting a finalized Target discards its trail as well
+	p.ForgetFinalized(loser.hash)
+	_, ok := p.GetFinalized(loser.hash)
+	assertFalse(t, ok)
+	assertTrue(t, len(p.GetAuditTrail(loser.hash)) == 0)
+}
+
+func TestAuditTrailLimit(t *testing.T) {
+	var (
+		connman = NewConnman()
+		nodeID  = NodeID(0)
+		tx      = &testTx{hash: Hash{1}, accepted: true}
+	)
+	connman.AddNode(nodeID)
+	p, err := NewProcessor(connman, DefaultConfig(), WithAuditTrail(3))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	for i := 0; i < 5; i++ {
+		assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{tx.hash: 1}, &[]StatusUpdate{}))
+	}
+
+	// Only the most recent entries are kept
+	trail := p.GetAuditTrail(tx.hash)
+	assertTrue(t, len(trail) == 3)
+	assertTrue(t, trail[2].Kind == AuditVote && trail[2].Round == p.GetRound()-1 && trail[2].Vote == 1)
+
+	// Nothing is recorded unless the trail is enabled
+	p = newTestProcessor(t, connman)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+	assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{tx.hash: 0}, &[]StatusUpdate{}))
+	assertTrue(t, len(p.GetAuditTrail(tx.hash)) == 0)
+}
@@ -1,25 +1,6 @@
 package avalanche
 
-import (
-	"sort"
-	"time"
-)
-
-const (
-	// AvalancheFinalizationScore is the confidence score we consider to be final
-	AvalancheFinalizationScore = 128
-
-	// AvalancheTimeStep is the amount of time to wait between event ticks
-	AvalancheTimeStep = 10 * time.Millisecond
-
-	// AvalancheMaxElementPoll is the maximum number of invs to send in a single
-	// query
-	AvalancheMaxElementPoll = 4096
-
-	// AvalancheRequestTimeout is the amount of time to wait for a response to a
-	// query
-	AvalancheRequestTimeout = 1 * time.Minute
-)
+import "time"
 
 // NodeID is the identifier for an avalanche node
 type NodeID int64
@@ -55,27 +36,49 @@ const (
 	StatusFinalized
 )
 
+// String returns the name of the Status
+func (s Status) String() string {
+	switch s {
+	case StatusInvalid:
+		return "invalid"
+	case StatusRejected:
+		return "rejected"
+	case StatusAccepted:
+		return "accepted"
+	case StatusFinalized:
+		return "finalized"
+	}
+	return "unknown"
+}
+
 // StatusUpdate represents a change in status for a particular Target
 type StatusUpdate struct {
 	Hash
 	Status
 }
 
+// Target types that have a wire code. Targets may use other types, but only
+// these can be sent to other nodes.
+const (
+	// TypeTx is the Type of transaction Targets
+	TypeTx = "tx"
+
+	// TypeBlock is the Type of block Targets
+	TypeBlock = "block"
+)
+
 // Inv is a poll request for a Target
 type Inv struct {
 	TargetType string
 	TargetHash Hash
 }
 
-// Hash is a unique digest that represents a Target
-type Hash int
-
 // Target is is something being decided by consensus; e.g. a transaction or block
 type Target interface {
 	// Hash returns the digest used as an ID for the Target
 	Hash() Hash
 
-	// Type is the kind of thing; e.g. "transaction" or "block"
+	// Type is the kind of thing; e.g. TypeTx or TypeBlock
 	Type() string
 
 	// IsAccepted returns whether or not the target should be considered accepted
@@ -106,80 +109,3 @@ type stubClocker struct{ t time.Time }
 
 // Now returns the stub's preset time
 func (c stubClocker) Now() time.Time { return c.t }
-
-//
-// Block stubs
-//
-var staticTestBlockMap = map[Hash]*Block{
-	Hash(65): {Hash(65), 99, true, true},
-	Hash(66): {Hash(66), 100, true, false},
-}
-
-func blockForHash(h Hash) *Block {
-	b, ok := staticTestBlockMap[h]
-
-	// TODO: replace with proper error handling
-	if !ok {
-		panic("Block not found with hash")
-	}
-
-	return b
-}
-
-// Block is a stub for Bitcoin block
-type Block struct {
-	hash            Hash
-	work            int64
-	valid           bool
-	isInActiveChain bool
-}
-
-// Hash returns the Blocks id
-func (b *Block) Hash() Hash {
-	return b.hash
-}
-
-// Type returns the Target type; in this case a block
-func (b *Block) Type() string {
-	return "block"
-}
-
-// Score returns the weight of the block against others; in the this the work
-func (b *Block) Score() int64 {
-	return b.work
-}
-
-// IsAccepted returns whether or not the Block has been accepted
-func (b *Block) IsAccepted() bool {
-	return b.isInActiveChain
-}
-
-// IsValid returns whether or not the Block is valid
-func (b *Block) IsValid() bool {
-	return b.valid
-}
-
-func sortBlockInvsByWork(invs []Inv) {
-	blocks := make(blocksByWork, len(invs))
-	for i, inv := range invs {
-		// TODO: Return error if a targetType is not "block"
-		blocks[i] = blockForHash(inv.TargetHash)
-	}
-
-	sort.Sort(blocks)
-
-	for i, b := range blocks {
-		invs[i] = Inv{"block", b.Hash()}
-	}
-}
-
-type blocksByWork []*Block
-
-// Len implements the sort interface's Len method for blocksByWork
-func (a blocksByWork) Len() int { return len(a) }
-
-// Swap implements the sort interface's Swap method for blocksByWork
-func (a blocksByWork) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-
-// Less implements the sort interface's Less method for blocksByWork
-func (a blocksByWork) Less(i, j int) bool { return a[i].work > a[j].work }
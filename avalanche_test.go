@@ -1,6 +1,14 @@
 package avalanche
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -19,12 +27,12 @@ func TestVoteRecord(t *testing.T) {
 		assertTrue(t, vr.getConfidence() == confidence)
 	}
 
-	vr = NewVoteRecord(true)
+	vr = NewVoteRecord(true, DefaultConfig())
 	assertTrue(t, vr.isAccepted())
 	assertFalse(t, vr.hasFinalized())
 	assertTrue(t, vr.getConfidence() == 0)
 
-	vr = NewVoteRecord(false)
+	vr = NewVoteRecord(false, DefaultConfig())
 	assertFalse(t, vr.isAccepted())
 	assertFalse(t, vr.hasFinalized())
 	assertTrue(t, vr.getConfidence() == 0)
@@ -52,17 +60,17 @@ func TestVoteRecord(t *testing.T) {
 	}
 
 	// Now confidence will increase as long as we vote yes.
-	for i := uint16(8); i < AvalancheFinalizationScore; i++ {
+	for i := uint16(8); i < DefaultFinalizationScore; i++ {
 		registerVoteAndCheck(0, true, false, i)
 	}
 
 	// The next vote will finalize the decision.
-	registerVoteAndCheck(1, true, true, AvalancheFinalizationScore)
+	registerVoteAndCheck(1, true, true, DefaultFinalizationScore)
 
 	// Now that we have two no votes, confidence stop increasing.
 	for i := uint16(0); i < 5; i++ {
 		registerVoteAndCheck(1, true, true,
-			AvalancheFinalizationScore)
+			DefaultFinalizationScore)
 	}
 
 	// Next vote will flip state, and confidence will increase as long as we
@@ -83,29 +91,72 @@ func TestVoteRecord(t *testing.T) {
 	}
 
 	// Now confidence will increase as long as we vote no.
-	for i := uint16(8); i < AvalancheFinalizationScore; i++ {
+	for i := uint16(8); i < DefaultFinalizationScore; i++ {
 		registerVoteAndCheck(1, false, false, i)
 	}
 
 	// The next vote will finalize the decision.
-	registerVoteAndCheck(0, false, true, AvalancheFinalizationScore)
+	registerVoteAndCheck(0, false, true, DefaultFinalizationScore)
+}
+
+func TestVoteRecordWindow(t *testing.T) {
+	for _, cfg := range []Config{
+		{VoteWindow: 16, VoteQuorum: 12},
+		{VoteWindow: 32, VoteQuorum: 20},
+		{VoteWindow: 64, VoteQuorum: 50},
+		{VoteWindow: 64},
+	} {
+		cfg = cfg.withDefaults()
+		vr := NewVoteRecord(false, cfg)
+
+		// We need quorum-1 positive votes before the round is conclusive.
+		for i := 0; i < cfg.VoteQuorum-1; i++ {
+			assertFalse(t, vr.regsiterVote(0))
+			assertFalse(t, vr.isAccepted())
+		}
+
+		// Next vote will flip state.
+		assertTrue(t, vr.regsiterVote(0))
+		assertTrue(t, vr.isAccepted())
+		assertTrue(t, vr.getConfidence() == 0)
+
+		// Enough neutral votes to drop below the quorum stall progress until
+		// they leave the window.
+		stall := cfg.VoteWindow - cfg.VoteQuorum + 1
+		for i := 0; i < stall; i++ {
+			vr.regsiterVote(negativeOne)
+		}
+		c := vr.getConfidence()
+		for i := 0; i < cfg.VoteWindow-stall; i++ {
+			vr.regsiterVote(0)
+			assertTrue(t, vr.getConfidence() == c)
+		}
+		vr.regsiterVote(0)
+		assertTrue(t, vr.getConfidence() == c+1)
+	}
 }
 func TestBlockRegister(t *testing.T) {
 	var (
 		connman = NewConnman()
-		p       = NewProcessor(connman)
+		p       = newTestProcessor(t, connman)
 		nodeID  = NodeID(0)
 
 		updates   = []StatusUpdate{}
-		blockHash = Hash(65)
-		pindex    = blockForHash(blockHash)
+		blockHash = Hash{65}
+		pindex    = newTestBlock(blockHash, 99, true)
 
-		noVote      = Response{votes: []Vote{NewVote(1, blockHash)}}
-		yesVote     = Response{votes: []Vote{NewVote(0, blockHash)}}
-		neutralVote = Response{votes: []Vote{NewVote(negativeOne, blockHash)}}
+		noVote      = []Vote{NewVote(1, blockHash)}
+		yesVote     = []Vote{NewVote(0, blockHash)}
+		neutralVote = []Vote{NewVote(negativeOne, blockHash)}
 	)
 	connman.AddNode(nodeID)
 
+	// poll runs the event loop and responds to the query it made
+	poll := func(votes []Vote) Response {
+		_, round, _ := p.eventLoop()
+		return NewResponse(round, 0, votes)
+	}
+
 	assertUpdateCount := func(c int) {
 		if len(updates) != c {
 			t.Fatal("Expected", c, "updates")
@@ -125,23 +176,20 @@ func TestBlockRegister(t *testing.T) {
 
 	// Vote for the block a few times
 	for i := 0; i < 6; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, yesVote, &updates))
+		assertNoError(t, p.RegisterVotes(nodeID, poll(yesVote), &updates))
 		assertTrue(t, p.IsAccepted(pindex))
 		assertConfidence(t, p, pindex, 0)
 		assertUpdateCount(0)
 	}
 
 	// A single neutral vote do not change anything.
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID, neutralVote, &updates))
+	assertNoError(t, p.RegisterVotes(nodeID, poll(neutralVote), &updates))
 	assertTrue(t, p.IsAccepted(pindex))
 	assertConfidence(t, p, pindex, 0)
 	assertUpdateCount(0)
 
 	for i := uint16(1); i < 7; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, yesVote, &updates))
+		assertNoError(t, p.RegisterVotes(nodeID, poll(yesVote), &updates))
 		assertTrue(t, p.IsAccepted(pindex))
 		assertConfidence(t, p, pindex, i)
 		assertUpdateCount(0)
@@ -149,25 +197,22 @@ func TestBlockRegister(t *testing.T) {
 
 	// Two neutral votes will stall progress.
 	for i := 0; i < 2; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, neutralVote, &updates))
+		assertNoError(t, p.RegisterVotes(nodeID, poll(neutralVote), &updates))
 		assertTrue(t, p.IsAccepted(pindex))
 		assertConfidence(t, p, pindex, 6)
 		assertUpdateCount(0)
 	}
 
 	for i := 2; i < 8; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, yesVote, &updates))
+		assertNoError(t, p.RegisterVotes(nodeID, poll(yesVote), &updates))
 		assertTrue(t, p.IsAccepted(pindex))
 		assertConfidence(t, p, pindex, 6)
 		assertUpdateCount(0)
 	}
 
 	// We vote on it numerous times to finalize it
-	for i := uint16(7); i < AvalancheFinalizationScore; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, yesVote, &updates))
+	for i := uint16(7); i < DefaultFinalizationScore; i++ {
+		assertNoError(t, p.RegisterVotes(nodeID, poll(yesVote), &updates))
 		assertTrue(t, p.IsAccepted(pindex))
 		assertConfidence(t, p, pindex, i)
 		assertUpdateCount(0)
@@ -178,8 +223,7 @@ func TestBlockRegister(t *testing.T) {
 	assertPollExistsForBlock(t, p, pindex)
 
 	// Now finalize the decision.
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID, yesVote, &updates))
+	assertNoError(t, p.RegisterVotes(nodeID, poll(yesVote), &updates))
 	assertUpdateCount(1)
 	if updates[0].Hash != blockHash {
 		t.Fatal("Update has incorrect hash. Got", updates[0].Hash, "but wanted:", blockHash)
@@ -198,15 +242,13 @@ func TestBlockRegister(t *testing.T) {
 	assertPollExistsForBlock(t, p, pindex)
 
 	for i := 0; i < 6; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, noVote, &updates))
+		assertNoError(t, p.RegisterVotes(nodeID, poll(noVote), &updates))
 		assertTrue(t, p.IsAccepted(pindex))
 		assertUpdateCount(0)
 	}
 
 	// Now the state will flip.
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID, noVote, &updates))
+	assertNoError(t, p.RegisterVotes(nodeID, poll(noVote), &updates))
 	assertFalse(t, p.IsAccepted(pindex))
 	assertUpdateCount(1)
 	if updates[0].Hash != blockHash {
@@ -218,9 +260,8 @@ func TestBlockRegister(t *testing.T) {
 	updates = []StatusUpdate{}
 
 	// Now it is rejected, but we can vote for it numerous times.
-	for i := 1; i < AvalancheFinalizationScore; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID, noVote, &updates))
+	for i := 1; i < DefaultFinalizationScore; i++ {
+		assertNoError(t, p.RegisterVotes(nodeID, poll(noVote), &updates))
 		assertFalse(t, p.IsAccepted(pindex))
 		assertUpdateCount(0)
 	}
@@ -230,8 +271,7 @@ func TestBlockRegister(t *testing.T) {
 	assertPollExistsForBlock(t, p, pindex)
 
 	// Now finalize the decision.
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID, yesVote, &updates))
+	assertNoError(t, p.RegisterVotes(nodeID, poll(yesVote), &updates))
 	assertFalse(t, p.IsAccepted(pindex))
 	assertUpdateCount(1)
 	if updates[0].Hash != blockHash {
@@ -254,31 +294,33 @@ func TestBlockRegister(t *testing.T) {
 func TestMultiBlockRegister(t *testing.T) {
 	var (
 		connman = NewConnman()
-		p       = NewProcessor(connman)
+		p       = newTestProcessor(t, connman)
 		nodeID0 = NodeID(0)
 		nodeID1 = NodeID(1)
 
 		updates = []StatusUpdate{}
 
-		blockHashA = Hash(65)
-		pindexA    = blockForHash(blockHashA)
-		blockHashB = Hash(66)
-		pindexB    = blockForHash(blockHashB)
+		blockHashA = Hash{65}
+		pindexA    = newTestBlock(blockHashA, 99, true)
+		blockHashB = Hash{66}
+		pindexB    = newTestBlock(blockHashB, 100, false)
 
-		round          = p.GetRound()
-		yesVoteForA    = Response{round, 0, []Vote{NewVote(0, blockHashA)}}
-		yesVoteForB    = Response{round + 1, 0, []Vote{NewVote(0, blockHashB)}}
-		yesVoteForBoth = Response{round + 1, 0, []Vote{NewVote(0, blockHashB), NewVote(0, blockHashA)}}
+		yesVoteForA    = []Vote{NewVote(0, blockHashA)}
+		yesVoteForB    = []Vote{NewVote(0, blockHashB)}
+		yesVoteForBoth = []Vote{NewVote(0, blockHashB), NewVote(0, blockHashA)}
 	)
 	connman.AddNode(nodeID0)
 	connman.AddNode(nodeID1)
 
-	// TODO: Implement polling both nodes like in the ABC tests. Currently it
-	// always uses node0. Need to fix the getSuitableNode logic in Processor
+	// Either node may be polled so we respond from whichever one was
+	var (
+		polled NodeID
+		round  int64
+	)
 
 	// TODO: The ABC tests don't change these afaict
 	// Figure out why this needs to be true
-	pindexB.isInActiveChain = true
+	pindexB.accepted = true
 
 	assertUpdateCount := func(c int) {
 		if len(updates) != c {
@@ -294,12 +336,11 @@ func TestMultiBlockRegister(t *testing.T) {
 	assertTrue(t, p.AddTargetToReconcile(pindexA))
 	assertBlockPollCount(t, p, 1)
 	assertPollExistsForBlock(t, p, pindexA)
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID0, yesVoteForA, &updates))
+	polled, round, _ = p.eventLoop()
+	assertNoError(t, p.RegisterVotes(polled, NewResponse(round, 0, yesVoteForA), &updates))
 	assertUpdateCount(0)
 
 	// Start voting on block B after one vote
-	p.round++
 	assertTrue(t, p.AddTargetToReconcile(pindexB))
 	assertBlockPollCount(t, p, 2)
 
@@ -314,15 +355,15 @@ func TestMultiBlockRegister(t *testing.T) {
 
 	// Let's vote for these blocks a few times
 	for i := 0; i < 4; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID0, yesVoteForBoth, &updates))
+		polled, round, _ = p.eventLoop()
+		assertNoError(t, p.RegisterVotes(polled, NewResponse(round, 0, yesVoteForBoth), &updates))
 		assertUpdateCount(0)
 	}
 
 	// Now it is accepted, but we can vote for it numerous times.
-	for i := 0; i < AvalancheFinalizationScore; i++ {
-		p.eventLoop()
-		assertTrue(t, p.RegisterVotes(nodeID0, yesVoteForBoth, &updates))
+	for i := 0; i < DefaultFinalizationScore; i++ {
+		polled, round, _ = p.eventLoop()
+		assertNoError(t, p.RegisterVotes(polled, NewResponse(round, 0, yesVoteForBoth), &updates))
 		assertUpdateCount(0)
 	}
 
@@ -331,8 +372,8 @@ func TestMultiBlockRegister(t *testing.T) {
 	// and B
 
 	// Next vote will finalize block A
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID0, yesVoteForBoth, &updates))
+	polled, round, _ = p.eventLoop()
+	assertNoError(t, p.RegisterVotes(polled, NewResponse(round, 0, yesVoteForBoth), &updates))
 	assertUpdateCount(1)
 	if updates[0].Hash != blockHashA {
 		t.Fatal("Update has incorrect hash. Got", updates[0].Hash, "but wanted:", blockHashA)
@@ -347,8 +388,8 @@ func TestMultiBlockRegister(t *testing.T) {
 	assertPollExistsForBlock(t, p, pindexB)
 
 	// Next vote will finalize block B
-	p.eventLoop()
-	assertTrue(t, p.RegisterVotes(nodeID0, yesVoteForB, &updates))
+	polled, round, _ = p.eventLoop()
+	assertNoError(t, p.RegisterVotes(polled, NewResponse(round, 0, yesVoteForB), &updates))
 	assertUpdateCount(1)
 	if updates[0].Hash != blockHashB {
 		t.Fatal("Update has incorrect hash. Got", updates[0].Hash, "but wanted:", blockHashB)
@@ -362,24 +403,836 @@ func TestMultiBlockRegister(t *testing.T) {
 	assertBlockPollCount(t, p, 0)
 }
 
+func TestGetSuitableNodeToQuery(t *testing.T) {
+	connman := NewConnman()
+	connman.AddNodeWithWeight(NodeID(0), 1)
+	connman.AddNodeWithWeight(NodeID(1), 2)
+	connman.AddNodeWithWeight(NodeID(2), 7)
+	connman.AddNodeWithWeight(NodeID(3), 0)
+
+	newSeededProcessor := func() *Processor {
+		p, err := NewProcessor(connman, DefaultConfig(), WithRand(rand.New(rand.NewSource(1))))
+		assertNoError(t, err)
+		assertTrue(t, p.AddTargetToReconcile(&testTx{hash: Hash{1}}))
+		return p
+	}
+
+	// Nodes are picked in proportion to their weight
+	p := newSeededProcessor()
+	counts := map[NodeID]int{}
+	for i := 0; i < 10000; i++ {
+		counts[p.getSuitableNodeToQuery()]++
+	}
+	assertTrue(t, counts[NodeID(0)] > 800 && counts[NodeID(0)] < 1200)
+	assertTrue(t, counts[NodeID(1)] > 1800 && counts[NodeID(1)] < 2200)
+	assertTrue(t, counts[NodeID(2)] > 6600 && counts[NodeID(2)] < 7400)
+	assertTrue(t, counts[NodeID(3)] == 0)
+
+	// The same seed picks the same nodes
+	p1, p2 := newSeededProcessor(), newSeededProcessor()
+	for i := 0; i < 100; i++ {
+		assertTrue(t, p1.getSuitableNodeToQuery() == p2.getSuitableNodeToQuery())
+	}
+
+	// Nodes with outstanding queries are skipped
+	p.StartQuery(NodeID(2))
+	p.StartQuery(NodeID(1))
+	for i := 0; i < 100; i++ {
+		assertTrue(t, p.getSuitableNodeToQuery() == NodeID(0))
+	}
+	p.StartQuery(NodeID(0))
+	assertTrue(t, p.getSuitableNodeToQuery() == NoNode)
+}
+
+func TestCooldown(t *testing.T) {
+	defer func(c clocker) { clock = c }(clock)
+	now := time.Now()
+	clock = stubClocker{now}
+
+	connman := NewConnman()
+	connman.AddNode(NodeID(0))
+	connman.AddNode(NodeID(1))
+
+	p := newTestProcessor(t, connman)
+	assertTrue(t, p.AddTargetToReconcile(&testTx{hash: Hash{1}, accepted: true}))
+
+	// A node that asks us to back off is skipped until its cooldown elapses
+	round, _ := p.StartQuery(NodeID(0))
+	resp := NewResponse(round, 100, []Vote{NewVote(0, Hash{1})})
+	assertTrue(t, resp.GetCooldownDuration() == 100*time.Millisecond)
+	assertNoError(t, p.RegisterVotes(NodeID(0), resp, &[]StatusUpdate{}))
+	for i := 0; i < 100; i++ {
+		assertTrue(t, p.getSuitableNodeToQuery() == NodeID(1))
+	}
+
+	clock = stubClocker{now.Add(100 * time.Millisecond)}
+	counts := map[NodeID]int{}
+	for i := 0; i < 100; i++ {
+		counts[p.getSuitableNodeToQuery()]++
+	}
+	assertTrue(t, counts[NodeID(0)] > 0 && counts[NodeID(1)] > 0)
+
+	// Invalid responses don't set a cooldown
+	round, _ = p.StartQuery(NodeID(1))
+	err := p.RegisterVotes(NodeID(1), NewResponse(round, 100, []Vote{}), &[]StatusUpdate{})
+	assertResponseError(t, ErrVoteCountMismatch, err)
+	assertFalse(t, p.isCoolingDown(NodeID(1), clock.Now()))
+
+	// Responders advertise a cooldown based on how many polls they answered
+	responder, err := NewProcessor(NewConnman(), DefaultConfig(),
+		WithCooldown(ProportionalCooldown(10*time.Millisecond, 25*time.Millisecond)))
+	assertNoError(t, err)
+	assertTrue(t, responder.AddTargetToReconcile(&testTx{hash: Hash{1}, accepted: true}))
+
+	invs := []Inv{{"tx", Hash{1}}, {"tx", Hash{2}}}
+	resp, err = responder.Respond(7, invs)
+	assertNoError(t, err)
+	assertTrue(t, resp.GetRound() == 7)
+	assertTrue(t, resp.GetCooldown() == 10)
+	assertTrue(t, resp.GetVotes()[0] == NewVote(0, Hash{1}))
+	assertTrue(t, resp.GetVotes()[1] == NewVote(voteUnknown, Hash{2}))
+
+	assertCooldown := func(p *Processor, round int64, cooldown uint32) {
+		resp, err := p.Respond(round, invs)
+		assertNoError(t, err)
+		assertTrue(t, resp.GetCooldown() == cooldown)
+	}
+	assertCooldown(responder, 8, 20)
+	assertCooldown(responder, 9, 25)
+
+	// Polls stop counting toward the load once they are old enough
+	clock = stubClocker{now.Add(100*time.Millisecond + loadWindow)}
+	assertCooldown(responder, 10, 10)
+
+	// By default no cooldown is advertised
+	assertCooldown(p, 1, 0)
+}
+
+func TestQueryTimeouts(t *testing.T) {
+	defer func(c clocker) { clock = c }(clock)
+	now := time.Now()
+	clock = stubClocker{now}
+
+	connman := NewConnman()
+	connman.AddNodeWithWeight(NodeID(0), 8)
+	connman.AddNodeWithWeight(NodeID(1), 8)
+
+	timedOut := []NodeID{}
+	p, err := NewProcessor(connman, DefaultConfig(), WithQueryTimeoutHandler(func(id NodeID, _ int64, invs []Inv) {
+		assertTrue(t, len(invs) == 1 && invs[0].TargetHash == Hash{1})
+		timedOut = append(timedOut, id)
+	}))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(&testTx{hash: Hash{1}, accepted: true}))
+
+	round, _ := p.StartQuery(NodeID(0))
+
+	// Nothing is swept before the timeout
+	assertTrue(t, len(p.sweepQueries()) == 0)
+	assertTrue(t, p.GetTimeouts(NodeID(0)) == 0)
+
+	// Expired queries are swept and their invs polled from another node
+	clock = stubClocker{now.Add(DefaultRequestTimeout + time.Second)}
+	p.Tick()
+	assertTrue(t, len(timedOut) == 1 && timedOut[0] == NodeID(0))
+	assertTrue(t, p.GetTimeouts(NodeID(0)) == 1)
+	assertTrue(t, p.GetTotalTimeouts() == 1)
+	_, requeued := p.queries[queryKey(round+1, NodeID(1))]
+	assertTrue(t, requeued)
+
+	// A late response is reported as expired
+	late := NewResponse(round, 0, []Vote{NewVote(0, Hash{1})})
+	assertResponseError(t, ErrExpiredQuery, p.RegisterVotes(NodeID(0), late, &[]StatusUpdate{}))
+
+	// The requeued query and the next poll to node 0 expire as well, and are
+	// requeued in new rounds to the other node
+	clock = stubClocker{now.Add(2 * (DefaultRequestTimeout + time.Second))}
+	p.tick()
+	assertTrue(t, len(timedOut) == 3 && timedOut[1] != timedOut[2])
+	assertTrue(t, len(p.queries) == 2)
+	for key := range p.queries {
+		assertTrue(t, key.round == round+3 || key.round == round+4)
+	}
+
+	// Each timeout halves the node's chance of being polled
+	assertTrue(t, p.GetTimeouts(NodeID(0)) == 2)
+	assertTrue(t, p.GetTimeouts(NodeID(1)) == 1)
+	assertTrue(t, p.penalizedWeight(NodeID(0), 8) == 2)
+	assertTrue(t, p.penalizedWeight(NodeID(1), 8) == 4)
+	p.timeouts[NodeID(0)] = 10
+	assertTrue(t, p.penalizedWeight(NodeID(0), 8) == 1)
+
+	// A valid response clears the penalty
+	assertNoError(t, respondToPoll(p, NodeID(0), map[Hash]uint32{Hash{1}: 0}, &[]StatusUpdate{}))
+	assertTrue(t, p.GetTimeouts(NodeID(0)) == 0)
+	assertTrue(t, p.GetTotalTimeouts() == 3)
+
+	// Old queries are forgotten by sweeping even if no more are made
+	assertTrue(t, len(p.answered) > 0 && len(p.expired) > 0)
+	clock = stubClocker{now.Add(4 * (DefaultRequestTimeout + time.Second))}
+	p.sweepQueries()
+	assertTrue(t, len(p.answered) == 0 && len(p.expired) == 0)
+}
+
+func TestSubscribe(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		nodeID  = NodeID(0)
+
+		txA   = &testTx{hash: Hash{1}, accepted: true}
+		txB   = &testTx{hash: Hash{2}, accepted: true}
+		block = newTestBlock(Hash{65}, 99, true)
+	)
+	connman.AddNode(nodeID)
+	assertTrue(t, p.AddTargetToReconcile(txA))
+	assertTrue(t, p.AddTargetToReconcile(txB))
+	assertTrue(t, p.AddTargetToReconcile(block))
+
+	var all, txs, onlyB []StatusUpdate
+	p.Subscribe(UpdateFilter{}, func(u StatusUpdate) {
+		// Changes are visible by the time they are delivered
+		if u.Hash == txA.Hash() {
+			assertTrue(t, p.IsAccepted(txA) == (u.Status == StatusAccepted))
+		}
+		all = append(all, u)
+	})
+	p.Subscribe(UpdateFilter{Types: []string{"tx"}}, func(u StatusUpdate) { txs = append(txs, u) })
+	unsubscribe := p.Subscribe(UpdateFilter{Hashes: []Hash{txB.Hash()}}, func(u StatusUpdate) { onlyB = append(onlyB, u) })
+	ch, unsubscribeChan := p.SubscribeChan(UpdateFilter{Hashes: []Hash{txA.Hash()}}, 8)
+
+	no := map[Hash]uint32{txA.Hash(): 1, txB.Hash(): 1, block.Hash(): 1}
+	updates := []StatusUpdate{}
+	for len(updates) == 0 {
+		assertNoError(t, respondToPoll(p, nodeID, no, &updates))
+	}
+
+	// Subscribers see the same updates as the caller, filtered
+	assertTrue(t, len(updates) == 3)
+	assertTrue(t, len(all) == 3)
+	for i := range updates {
+		assertTrue(t, all[i] == updates[i])
+	}
+	assertTrue(t, len(txs) == 2)
+	assertTrue(t, len(onlyB) == 1 && onlyB[0] == StatusUpdate{txB.Hash(), StatusRejected})
+	assertTrue(t, <-ch == StatusUpdate{txA.Hash(), StatusRejected})
+
+	// Cancelled subscriptions receive nothing more
+	unsubscribe()
+	unsubscribeChan()
+	_, open := <-ch
+	assertFalse(t, open)
+
+	yes := map[Hash]uint32{txA.Hash(): 0, txB.Hash(): 0, block.Hash(): 0}
+	for len(all) == 3 {
+		assertNoError(t, respondToPoll(p, nodeID, yes, &updates))
+	}
+	assertTrue(t, len(onlyB) == 1)
+}
+
+func TestSubscribeChanDoesNotBlock(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		nodeID  = NodeID(0)
+		tx      = &testTx{hash: Hash{1}, accepted: true}
+	)
+	connman.AddNode(nodeID)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	// Nobody reads from the unbuffered channel while votes are registered
+	ch, unsubscribe := p.SubscribeChan(UpdateFilter{}, 0)
+	defer unsubscribe()
+
+	updates := []StatusUpdate{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, vote := range []uint32{1, 0} {
+			for n := len(updates); len(updates) == n; {
+				assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{tx.hash: vote}, &updates))
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Registering votes blocked on an undrained channel")
+	}
+
+	// The reader can query the Processor before draining
+	assertTrue(t, p.IsAccepted(tx))
+	assertTrue(t, len(updates) == 2)
+	for _, u := range updates {
+		assertTrue(t, <-ch == u)
+	}
+}
+
+func TestSignedResponses(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		signed  = NodeID(0)
+		keyless = NodeID(1)
+		tx      = &testTx{hash: Hash{1}, accepted: true}
+		updates = []StatusUpdate{}
+	)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assertNoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	assertNoError(t, err)
+
+	connman.AddNodeWithInfo(NodeInfo{ID: signed, Weight: 1, PublicKey: pub})
+	connman.AddNode(keyless)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	// A responder with an identity key signs its responses
+	responder, err := NewProcessor(NewConnman(), DefaultConfig(), WithIdentityKey(priv))
+	assertNoError(t, err)
+
+	round, invs := p.StartQuery(signed)
+	resp, err := responder.Respond(round, invs)
+	assertNoError(t, err)
+	assertTrue(t, resp.Verify(pub))
+	assertNoError(t, p.RegisterVotes(signed, resp, &updates))
+
+	// Responses that cannot be signed are not returned unsigned
+	tooMany := make([]Inv, MaxWireElements+1)
+	_, err = responder.Respond(round+1, tooMany)
+	assertTrue(t, err == ErrTooManyElements)
+
+	// Unsigned, forged, and tampered responses are rejected without consuming
+	// the query
+	round, invs = p.StartQuery(signed)
+	unsigned := NewResponse(round, 0, []Vote{NewVote(0, invs[0].TargetHash)})
+	assertResponseError(t, ErrInvalidSignature, p.RegisterVotes(signed, unsigned, &updates))
+
+	forged, err := unsigned.Sign(otherPriv)
+	assertNoError(t, err)
+	assertResponseError(t, ErrInvalidSignature, p.RegisterVotes(signed, forged, &updates))
+
+	tampered, err := unsigned.Sign(priv)
+	assertNoError(t, err)
+	tampered.cooldown = 1000
+	assertResponseError(t, ErrInvalidSignature, p.RegisterVotes(signed, tampered, &updates))
+
+	valid, err := unsigned.Sign(priv)
+	assertNoError(t, err)
+	assertNoError(t, p.RegisterVotes(signed, valid, &updates))
+
+	// Nodes without a key may respond unsigned unless signatures are required
+	assertNoError(t, respondToPoll(p, keyless, map[Hash]uint32{}, &updates))
+
+	strict, err := NewProcessor(connman, DefaultConfig(), WithRequiredSignatures())
+	assertNoError(t, err)
+	assertTrue(t, strict.AddTargetToReconcile(tx))
+	assertResponseError(t, ErrUnknownNodeKey, respondToPoll(strict, keyless, map[Hash]uint32{}, &updates))
+}
+
+func TestPollPriority(t *testing.T) {
+	var (
+		low   = &testTx{hash: Hash{1}, score: 1}
+		high  = &testTx{hash: Hash{2}, score: 3}
+		tieA  = &testTx{hash: Hash{3}, score: 2}
+		tieB  = &testTx{hash: Hash{4}, score: 2}
+		block = newTestBlock(Hash{65}, 99, true)
+	)
+
+	pollOrder := func(p *Processor) []Hash {
+		for _, target := range []Target{low, tieB, block, high, tieA} {
+			assertTrue(t, p.AddTargetToReconcile(target))
+		}
+
+		hashes := []Hash{}
+		for _, inv := range p.GetInvsForNextPoll() {
+			hashes = append(hashes, inv.TargetHash)
+		}
+		return hashes
+	}
+
+	assertOrder := func(actual []Hash, expected ...Hash) {
+		assertTrue(t, len(actual) == len(expected))
+		for i := range expected {
+			if actual[i] != expected[i] {
+				t.Fatal("Expected", expected, "but got", actual)
+			}
+		}
+	}
+
+	// Invs are ordered by score, with ties broken by hash
+	p := newTestProcessor(t, NewConnman())
+	assertOrder(pollOrder(p), block.Hash(), high.Hash(), tieA.Hash(), tieB.Hash(), low.Hash())
+
+	// Types can be given priority over others
+	p, err := NewProcessor(NewConnman(), DefaultConfig(), WithTypePriority(map[string]int{"tx": 1}))
+	assertNoError(t, err)
+	assertOrder(pollOrder(p), high.Hash(), tieA.Hash(), tieB.Hash(), low.Hash(), block.Hash())
+
+	// The least important invs are dropped when there are too many to poll
+	p, err = NewProcessor(NewConnman(), Config{MaxElementPoll: 2})
+	assertNoError(t, err)
+	assertOrder(pollOrder(p), block.Hash(), high.Hash())
+}
+
+func TestConflictSet(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		nodeID  = NodeID(0)
+
+		updates = []StatusUpdate{}
+		txA     = &testTx{hash: Hash{1}, accepted: true, conflicts: []string{"outpoint"}}
+		txB     = &testTx{hash: Hash{2}, accepted: true, conflicts: []string{"outpoint"}}
+		txC     = &testTx{hash: Hash{3}, accepted: true, conflicts: []string{"outpoint"}}
+
+		yesForA = map[Hash]uint32{txA.Hash(): 0}
+		yesForB = map[Hash]uint32{txB.Hash(): 0}
+	)
+	connman.AddNode(nodeID)
+
+	assertUpdate := func(i int, h Hash, s Status) {
+		if len(updates) <= i || updates[i].Hash != h || updates[i].Status != s {
+			t.Fatal("Expected update", i, "to be", h, s, "but got", updates)
+		}
+	}
+
+	// The first target is preferred and accepted, conflicting ones are not
+	assertTrue(t, p.AddTargetToReconcile(txA))
+	assertTrue(t, p.AddTargetToReconcile(txB))
+	assertTrue(t, p.IsAccepted(txA))
+	assertFalse(t, p.IsAccepted(txB))
+	preferred, ok := p.GetConflictPreference("outpoint")
+	assertTrue(t, ok && preferred == txA.Hash())
+
+	// A gets a few conclusive rounds
+	for i := 0; i < 10; i++ {
+		assertNoError(t, respondToPoll(p, nodeID, yesForA, &updates))
+		assertTrue(t, len(updates) == 0)
+	}
+
+	// B does not take over until it has had more conclusive rounds than A
+	for i := 0; i < 11; i++ {
+		assertNoError(t, respondToPoll(p, nodeID, yesForB, &updates))
+		assertTrue(t, len(updates) == 0)
+		assertTrue(t, p.IsAccepted(txA))
+		assertFalse(t, p.IsAccepted(txB))
+	}
+	assertNoError(t, respondToPoll(p, nodeID, yesForB, &updates))
+	assertTrue(t, len(updates) == 2)
+	assertUpdate(0, txA.Hash(), StatusRejected)
+	assertUpdate(1, txB.Hash(), StatusAccepted)
+	assertFalse(t, p.IsAccepted(txA))
+	assertTrue(t, p.IsAccepted(txB))
+	preferred, ok = p.GetConflictPreference("outpoint")
+	assertTrue(t, ok && preferred == txB.Hash())
+	updates = []StatusUpdate{}
+
+	// Finalizing B finalizes the rejection of all other members
+	assertTrue(t, p.AddTargetToReconcile(txC))
+	assertFalse(t, p.IsAccepted(txC))
+	for i := 0; i < DefaultFinalizationScore; i++ {
+		assertNoError(t, respondToPoll(p, nodeID, yesForB, &updates))
+	}
+	assertTrue(t, len(updates) == 3)
+	assertUpdate(0, txB.Hash(), StatusFinalized)
+	assertUpdate(1, txA.Hash(), StatusInvalid)
+	assertUpdate(2, txC.Hash(), StatusInvalid)
+	assertBlockPollCount(t, p, 0)
+	_, ok = p.GetConflictPreference("outpoint")
+	assertFalse(t, ok)
+}
+
+func TestDAG(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		nodeID  = NodeID(0)
+
+		updates = []StatusUpdate{}
+		txA     = &testTx{hash: Hash{1}, accepted: true}
+		txB     = &testTx{hash: Hash{2}, accepted: true, parents: []Hash{txA.Hash()}}
+		txC     = &testTx{hash: Hash{3}, accepted: true, parents: []Hash{txB.Hash()}}
+		txD     = &testTx{hash: Hash{4}, accepted: false, parents: []Hash{txA.Hash()}, conflicts: []string{"x"}}
+		txE     = &testTx{hash: Hash{5}, accepted: true, parents: []Hash{txD.Hash()}}
+		txG     = &testTx{hash: Hash{7}, accepted: true, conflicts: []string{"x"}}
+
+		yesForC = map[Hash]uint32{txC.Hash(): 0}
+		yesForG = map[Hash]uint32{txG.Hash(): 0}
+	)
+	connman.AddNode(nodeID)
+
+	// Children may be added before their parents
+	assertTrue(t, p.AddTargetToReconcile(txC))
+	assertTrue(t, p.AddTargetToReconcile(txA))
+	assertTrue(t, p.AddTargetToReconcile(txB))
+	assertTrue(t, p.AddTargetToReconcile(txD))
+	assertTrue(t, p.AddTargetToReconcile(txE))
+	assertTrue(t, p.AddTargetToReconcile(txG))
+
+	// Only the tips of the DAG are polled
+	assertBlockPollCount(t, p, 3)
+	assertPollExistsForTarget(t, p, txC)
+	assertPollExistsForTarget(t, p, txE)
+	assertPollExistsForTarget(t, p, txG)
+
+	// E is not strongly preferred because its parent D is not preferred
+	assertTrue(t, p.IsStronglyPreferred(txC))
+	assertFalse(t, p.IsStronglyPreferred(txD))
+	assertTrue(t, p.IsAccepted(txE))
+	assertFalse(t, p.IsStronglyPreferred(txE))
+
+	// Rejecting D rejects everything built on it. D is not polled while it has
+	// children, so it is rejected through G winning their conflict set.
+	for i := 0; i < 7+DefaultFinalizationScore; i++ {
+		pollAndRespond(t, p, nodeID, yesForG, &updates)
+	}
+	if len(updates) != 3 || updates[0] != (StatusUpdate{txG.Hash(), StatusFinalized}) ||
+		updates[1] != (StatusUpdate{txD.Hash(), StatusInvalid}) ||
+		updates[2] != (StatusUpdate{txE.Hash(), StatusInvalid}) {
+		t.Fatal("Expected G to be finalized and D and E to be invalidated but got", updates)
+	}
+	updates = []StatusUpdate{}
+	assertBlockPollCount(t, p, 1)
+
+	// Votes for C count toward B and A
+	for i := 0; i < 7; i++ {
+		pollAndRespond(t, p, nodeID, yesForC, &updates)
+	}
+	assertTrue(t, len(updates) == 0)
+	assertTrue(t, p.GetConfidence(txA) == 1)
+	assertTrue(t, p.GetConfidence(txB) == 1)
+	assertTrue(t, p.GetConfidence(txC) == 1)
+
+	// Confidence in the DAG is computed over the progeny
+	assertTrue(t, p.GetDAGConfidence(txA) == 3)
+	assertTrue(t, p.GetDAGConfidence(txB) == 2)
+	assertTrue(t, p.GetDAGConfidence(txC) == 1)
+	assertTrue(t, p.GetDAGConfidence(txD) == 0)
+
+	// Ancestors are finalized before their descendants
+	for i := 8; i < DefaultFinalizationScore+6; i++ {
+		pollAndRespond(t, p, nodeID, yesForC, &updates)
+		assertTrue(t, len(updates) == 0)
+	}
+	pollAndRespond(t, p, nodeID, yesForC, &updates)
+	if len(updates) != 3 || updates[0] != (StatusUpdate{txA.Hash(), StatusFinalized}) ||
+		updates[1] != (StatusUpdate{txB.Hash(), StatusFinalized}) ||
+		updates[2] != (StatusUpdate{txC.Hash(), StatusFinalized}) {
+		t.Fatal("Expected A, B, and C to be finalized in order but got", updates)
+	}
+	assertBlockPollCount(t, p, 0)
+}
+
+// pollAndRespond polls the node and answers with the given votes, voting
+// neutral on anything else
+func pollAndRespond(t *testing.T, p *Processor, nodeID NodeID, votes map[Hash]uint32, updates *[]StatusUpdate) {
+	assertNoError(t, respondToPoll(p, nodeID, votes, updates))
+}
+
 func TestProcessorEventLoop(t *testing.T) {
-	p := NewProcessor(NewConnman())
+	p := newTestProcessor(t, NewConnman())
 
 	// Start loop
-	assertTrue(t, p.start())
+	assertNoError(t, p.Start(context.Background()))
+	assertTrue(t, p.IsRunning())
 
 	// Can't start it twice
-	assertFalse(t, p.start())
+	assertTrue(t, p.Start(context.Background()) == ErrAlreadyRunning)
 
 	// Stop loop
-	assertTrue(t, p.stop())
+	assertNoError(t, p.Stop(context.Background()))
+	assertFalse(t, p.IsRunning())
 
 	// Can't stop twice
-	assertFalse(t, p.stop())
+	assertTrue(t, p.Stop(context.Background()) == ErrNotRunning)
 
 	// You can restart it and stop it again
-	assertTrue(t, p.start())
-	assertTrue(t, p.stop())
+	assertNoError(t, p.Start(context.Background()))
+	assertNoError(t, p.Stop(context.Background()))
+
+	// The loop ends when its context is done and can be started again
+	ctx, cancel := context.WithCancel(context.Background())
+	assertNoError(t, p.Start(ctx))
+	cancel()
+	for p.IsRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	assertNoError(t, p.Start(context.Background()))
+	assertNoError(t, p.Stop(context.Background()))
+}
+
+func TestProcessorEventLoopRecoversFromPanics(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		target  = &panicTarget{testTx: testTx{hash: Hash{1}}}
+	)
+	connman.AddNode(NodeID(0))
+	assertTrue(t, p.AddTargetToReconcile(target))
+
+	// Every tick panics but the loop keeps going
+	assertNoError(t, p.Start(context.Background()))
+	for atomic.LoadInt32(&target.calls) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	assertTrue(t, p.IsRunning())
+	assertNoError(t, p.Stop(context.Background()))
+
+	// Driving the Processor directly surfaces the panic to the caller
+	defer func() {
+		assertTrue(t, recover() != nil)
+	}()
+	p.Tick()
+	t.Fatal("Expected Tick to panic")
+}
+
+func TestProcessorRecoversFromResponsePanics(t *testing.T) {
+	var (
+		logger = &testLogger{}
+		nodeID = NodeID(0)
+		target = &panicTarget{testTx: testTx{hash: Hash{1}}}
+	)
+	p, err := NewProcessor(NewConnman(), DefaultConfig(), WithLogger(logger))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(target))
+
+	// Registering the response panics, which is logged rather than crashing
+	// the loop or leaving the state locked
+	round := p.GetRound()
+	p.queries[queryKey(round, nodeID)] = NewRequestRecord(clock.Now().UnixNano(), []Inv{{"tx", target.Hash()}})
+	p.handleResponse(IncomingResponse{nodeID, NewResponse(round, 0, []Vote{NewVote(0, target.Hash())})})
+	assertTrue(t, logger.has("error", "recovered from panic while registering a response", "node", nodeID))
+	assertTrue(t, p.GetRound() == round)
+}
+
+func TestProcessorStopDrainsQueries(t *testing.T) {
+	var (
+		p       = newTestProcessor(t, NewConnman())
+		nodeID  = NodeID(0)
+		updates = []StatusUpdate{}
+		tx      = &testTx{hash: Hash{1}, accepted: true}
+		votes   = []Vote{NewVote(0, tx.Hash())}
+	)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	// Outstanding queries can be answered while stopping
+	assertNoError(t, p.Start(context.Background()))
+	round, _ := p.StartQuery(nodeID)
+	stopped := make(chan error)
+	go func() { stopped <- p.Stop(context.Background()) }()
+	time.Sleep(5 * DefaultTimeStep)
+	assertFalse(t, p.IsRunning())
+	assertNoError(t, p.RegisterVotes(nodeID, NewResponse(round, 0, votes), &updates))
+	assertNoError(t, <-stopped)
+
+	// Queries still outstanding when the context is done are cancelled
+	assertNoError(t, p.Start(context.Background()))
+	round, _ = p.StartQuery(nodeID)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assertNoError(t, p.Stop(ctx))
+	assertResponseError(t, ErrUnknownQuery, p.RegisterVotes(nodeID, NewResponse(round, 0, votes), &updates))
+}
+
+func TestConfig(t *testing.T) {
+	// Zero values are replaced with defaults
+	p, err := NewProcessor(NewConnman(), Config{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if p.Config() != DefaultConfig() {
+		t.Fatal("Expected default config. Got:", p.Config())
+	}
+
+	// Explicit values are kept
+	cfg := Config{FinalizationScore: 4, MaxElementPoll: 1}
+	p, err = NewProcessor(NewConnman(), cfg)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if p.Config().FinalizationScore != 4 || p.Config().MaxElementPoll != 1 {
+		t.Fatal("Config values were not kept. Got:", p.Config())
+	}
+	if p.Config().RequestTimeout != DefaultRequestTimeout {
+		t.Fatal("Expected default request timeout. Got:", p.Config().RequestTimeout)
+	}
+
+	// A smaller finalization score finalizes sooner
+	vr := NewVoteRecord(true, cfg)
+	for i := 0; i < 6+4; i++ {
+		assertFalse(t, vr.hasFinalized())
+		vr.regsiterVote(0)
+	}
+	assertTrue(t, vr.hasFinalized())
+
+	// Invalid values are rejected
+	invalid := map[error]Config{
+		ErrInvalidFinalizationScore: {FinalizationScore: -1},
+		ErrInvalidTimeStep:          {TimeStep: -1},
+		ErrInvalidMaxElementPoll:    {MaxElementPoll: -1},
+		ErrInvalidRequestTimeout:    {RequestTimeout: -1},
+		ErrInvalidVoteWindow:        {VoteWindow: 65},
+		ErrInvalidVoteQuorum:        {VoteWindow: 16, VoteQuorum: 8},
+	}
+	for expectedErr, cfg := range invalid {
+		if _, err := NewProcessor(NewConnman(), cfg); err != expectedErr {
+			t.Fatal("Expected error", expectedErr, "but got", err)
+		}
+	}
+
+	// Polls must fit in a wire message
+	if err := (Config{MaxElementPoll: MaxWireElements + 1}).withDefaults().Validate(); err != ErrInvalidMaxElementPoll {
+		t.Fatal("Expected error", ErrInvalidMaxElementPoll, "but got", err)
+	}
+	assertNoError(t, Config{MaxElementPoll: MaxWireElements}.withDefaults().Validate())
+}
+
+func TestSubSecondQueryTimeout(t *testing.T) {
+	defer func(c clocker) { clock = c }(clock)
+	now := time.Unix(1000, int64(999*time.Millisecond))
+	clock = stubClocker{now}
+
+	var (
+		connman   = NewConnman()
+		avanode   = NodeID(0)
+		blockHash = Hash{65}
+		timeout   = 200 * time.Millisecond
+	)
+	connman.AddNode(avanode)
+
+	p, err := NewProcessor(connman, Config{RequestTimeout: timeout})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	assertTrue(t, p.AddTargetToReconcile(newTestBlock(blockHash, 99, true)))
+
+	round := p.GetRound()
+	p.eventLoop()
+	r, ok := p.queries[queryKey(round, avanode)]
+	assertTrue(t, ok)
+
+	// A query is still live just after the poll even though the second changed
+	clock = stubClocker{now.Add(time.Millisecond)}
+	assertFalse(t, r.IsExpired(timeout))
+
+	// It expires once the timeout has passed
+	clock = stubClocker{now.Add(timeout + time.Millisecond)}
+	assertTrue(t, r.IsExpired(timeout))
+}
+
+type testTx struct {
+	hash      Hash
+	accepted  bool
+	score     int64
+	conflicts []string
+	parents   []Hash
+}
+
+func (tx *testTx) Hash() Hash { return tx.hash }
+
+func (*testTx) Type() string { return "tx" }
+
+func (tx *testTx) IsAccepted() bool { return tx.accepted }
+
+func (tx *testTx) Score() int64 { return tx.score }
+
+func (*testTx) IsValid() bool { return true }
+
+func (tx *testTx) Conflicts() []string { return tx.conflicts }
+
+func (tx *testTx) Parents() []Hash { return tx.parents }
+
+// testBlock is a block in a chain
+type testBlock struct {
+	hash     Hash
+	work     int64
+	valid    bool
+	accepted bool
+}
+
+// newTestBlock returns a valid block with the given cumulative work
+func newTestBlock(h Hash, work int64, accepted bool) *testBlock {
+	return &testBlock{hash: h, work: work, valid: true, accepted: accepted}
+}
+
+func (b *testBlock) Hash() Hash { return b.hash }
+
+func (*testBlock) Type() string { return TypeBlock }
+
+func (b *testBlock) IsAccepted() bool { return b.accepted }
+
+func (b *testBlock) Score() int64 { return b.work }
+
+func (b *testBlock) IsValid() bool { return b.valid }
+
+// panicTarget panics whenever it is checked for validity after being added
+type panicTarget struct {
+	testTx
+	calls int32
+}
+
+func (t *panicTarget) IsValid() bool {
+	if atomic.AddInt32(&t.calls, 1) > 1 {
+		panic("target exploded")
+	}
+	return true
+}
+
+// hashFromInt returns a Hash with the integer encoded into its first bytes
+func hashFromInt(i int) Hash {
+	h := Hash{}
+	binary.LittleEndian.PutUint64(h[:], uint64(i))
+	return h
+}
+
+func newTestProcessor(t *testing.T, connman *Connman) *Processor {
+	p, err := NewProcessor(connman, DefaultConfig())
+	if err != nil {
+		t.Fatal("Failed to create processor:", err)
+	}
+	return p
+}
+
+func TestProcessorConcurrency(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		wg      = sync.WaitGroup{}
+	)
+
+	// The event loop polls this node while others are polled directly
+	connman.AddNode(NodeID(100))
+	assertNoError(t, p.Start(context.Background()))
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			nodeID := NodeID(i)
+
+			for j := 0; j < 100; j++ {
+				tx := &testTx{hash: hashFromInt(i*100 + j), accepted: true}
+				p.AddTargetToReconcile(tx)
+				p.IsAccepted(tx)
+				p.GetRound()
+
+				err := respondToPoll(p, nodeID, map[Hash]uint32{}, &[]StatusUpdate{})
+				if err != nil {
+					t.Error("Unexpected error:", err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Cancel the event loop's queries rather than waiting for them
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assertNoError(t, p.Stop(ctx))
+	assertBlockPollCount(t, p, 400)
 }
 
 func assertTrue(t *testing.T, actual bool) {
@@ -394,6 +1247,36 @@ func assertFalse(t *testing.T, actual bool) {
 	}
 }
 
+// respondToPoll starts a query to the node and answers it with the given votes,
+// voting neutral on anything else
+func respondToPoll(p *Processor, nodeID NodeID, votes map[Hash]uint32, updates *[]StatusUpdate) error {
+	round, invs := p.StartQuery(nodeID)
+
+	resp := make([]Vote, len(invs))
+	for i, inv := range invs {
+		err, ok := votes[inv.TargetHash]
+		if !ok {
+			err = negativeOne
+		}
+		resp[i] = NewVote(err, inv.TargetHash)
+	}
+
+	return p.RegisterVotes(nodeID, NewResponse(round, 0, resp), updates)
+}
+
+func assertNoError(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal("Expected no error; got", err)
+	}
+}
+
+func assertResponseError(t *testing.T, expected error, err error) {
+	respErr, ok := err.(*ResponseError)
+	if !ok || respErr.Err != expected {
+		t.Fatal("Expected response error", expected, "but got", err)
+	}
+}
+
 func assertBlockPollCount(t *testing.T, p *Processor, count int) {
 	invs := p.GetInvsForNextPoll()
 	if len(invs) != count {
@@ -401,7 +1284,11 @@ func assertBlockPollCount(t *testing.T, p *Processor, count int) {
 	}
 }
 
-func assertPollExistsForBlock(t *testing.T, p *Processor, b *Block) {
+func assertPollExistsForBlock(t *testing.T, p *Processor, b *testBlock) {
+	assertPollExistsForTarget(t, p, b)
+}
+
+func assertPollExistsForTarget(t *testing.T, p *Processor, b Target) {
 	found := false
 	for _, inv := range p.GetInvsForNextPoll() {
 		if inv.TargetHash == b.Hash() {
@@ -414,7 +1301,7 @@ func assertPollExistsForBlock(t *testing.T, p *Processor, b *Block) {
 	}
 }
 
-func assertConfidence(t *testing.T, p *Processor, b *Block, expectedC uint16) {
+func assertConfidence(t *testing.T, p *Processor, b *testBlock, expectedC uint16) {
 	if c := p.GetConfidence(b); c != expectedC {
 		t.Fatal("Incorrect confidence. Got:", c, "Wanted:", expectedC)
 	}
@@ -423,13 +1310,13 @@ func assertConfidence(t *testing.T, p *Processor, b *Block, expectedC uint16) {
 func TestPollAndResponse(t *testing.T) {
 	var (
 		connman = NewConnman()
-		p       = NewProcessor(connman)
+		p       = newTestProcessor(t, connman)
 		avanode = NodeID(0)
 
 		updates = []StatusUpdate{}
 
-		blockHash = Hash(65)
-		pindex    = blockForHash(blockHash)
+		blockHash = Hash{65}
+		pindex    = newTestBlock(blockHash, 99, true)
 	)
 	connman.AddNode(avanode)
 
@@ -450,12 +1337,11 @@ func TestPollAndResponse(t *testing.T) {
 	// Trigger a poll on avanode
 	round := p.GetRound()
 	p.eventLoop()
-	// TODO: We should put nodes on a request timer and make this assertion pass
-	// assertTrue(t, p.getSuitableNodeToQuery() == NoNode)
+	assertTrue(t, p.getSuitableNodeToQuery() == NoNode)
 
 	// Response to the request
-	vote := Response{round, 0, []Vote{NewVote(0, blockHash)}}
-	assertTrue(t, p.RegisterVotes(avanode, vote, &updates))
+	vote := NewResponse(round, 0, []Vote{NewVote(0, blockHash)})
+	assertNoError(t, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
 	// Now that avanode fulfilled his request it is added back to the list of
@@ -463,84 +1349,129 @@ func TestPollAndResponse(t *testing.T) {
 	assertTrue(t, p.getSuitableNodeToQuery() == avanode)
 
 	// Sending response when not polled fails
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	assertResponseError(t, ErrDuplicateResponse, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
 	// Trigger a poll on avanode
 	round = p.GetRound()
 	p.eventLoop()
-	// TODO: We should put nodes on a request timer and make this assertion pass
-	// assertTrue(t, p.getSuitableNodeToQuery() == NoNode)
+	assertTrue(t, p.getSuitableNodeToQuery() == NoNode)
 
 	// Sending responses that do not match the request also fails.
 	// 1. Too many results.
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{NewVote(0, blockHash), NewVote(0, blockHash)}}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHash), NewVote(0, blockHash)})
+	assertResponseError(t, ErrVoteCountMismatch, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
 	// 2. Not enough results.
+	round = p.GetRound()
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{}}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{})
+	assertResponseError(t, ErrVoteCountMismatch, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
 	// 3. Do not match the poll
+	round = p.GetRound()
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{{}}}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{{}})
+	assertResponseError(t, ErrVoteHashMismatch, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
 	// 4.Invalid round count. Request is not discarded
+	round = p.GetRound()
 	p.eventLoop()
-	vote = Response{round + 1, 0, []Vote{NewVote(0, blockHash)}}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round+1, 0, []Vote{NewVote(0, blockHash)})
+	assertResponseError(t, ErrUnknownQuery, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
-	vote = Response{round - 1, 0, []Vote{NewVote(0, blockHash)}}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	// The previous round has already been answered
+	vote = NewResponse(round-1, 0, []Vote{NewVote(0, blockHash)})
+	assertResponseError(t, ErrDuplicateResponse, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
 	// 5. Making request for invalid nodes do not work. Request is not discarded
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{NewVote(0, blockHash)}}
-	assertFalse(t, p.RegisterVotes(NodeID(1234), vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHash)})
+	assertResponseError(t, ErrUnknownQuery, p.RegisterVotes(NodeID(1234), vote, &updates))
 	assertUpdateCount(0)
 
 	// Proper response gets processed and avanode is available again.
-	vote = Response{round, 0, []Vote{NewVote(0, blockHash)}}
-	assertTrue(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHash)})
+	assertNoError(t, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 
-	// Out of order response are rejected.
-	blockHashB := Hash(66)
-	pindexB := blockForHash(blockHashB)
+	blockHashB := Hash{66}
+	pindexB := newTestBlock(blockHashB, 100, false)
 	assertTrue(t, p.AddTargetToReconcile(pindexB))
 
+	// Invs are polled in order of work, so out of order responses are rejected
+	round = p.GetRound()
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{NewVote(0, blockHash), NewVote(0, blockHashB)}}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHash), NewVote(0, blockHashB)})
+	assertResponseError(t, ErrVoteHashMismatch, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 	assertTrue(t, p.getSuitableNodeToQuery() == avanode)
 
 	// But they are accepted in order
+	round = p.GetRound()
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{NewVote(0, blockHashB), NewVote(0, blockHash)}}
-	assertTrue(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHashB), NewVote(0, blockHash)})
+	assertNoError(t, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 	assertTrue(t, p.getSuitableNodeToQuery() == avanode)
 
 	// When a block is marked invalid, stop polling.
 	pindexB.valid = false
+	round = p.GetRound()
 	p.eventLoop()
-	vote = Response{round, 0, []Vote{NewVote(0, blockHash)}}
-	assertTrue(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHash)})
+	assertNoError(t, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 	assertTrue(t, p.getSuitableNodeToQuery() == avanode)
 
 	// Expire requests after some time.
+	round = p.GetRound()
 	p.eventLoop()
-	clock = stubClocker{time.Now().Add(1 * time.Minute)}
-	assertFalse(t, p.RegisterVotes(avanode, vote, &updates))
+	vote = NewResponse(round, 0, []Vote{NewVote(0, blockHash)})
+	clock = stubClocker{time.Now().Add(DefaultRequestTimeout)}
+	assertResponseError(t, ErrExpiredQuery, p.RegisterVotes(avanode, vote, &updates))
 	assertUpdateCount(0)
 }
+
+func TestHash(t *testing.T) {
+	str := strings.Repeat("00", HashSize-2) + "ff01"
+	h, err := NewHashFromStr(str)
+	assertNoError(t, err)
+	assertTrue(t, h[0] == 0x01 && h[1] == 0xff)
+	assertTrue(t, h.String() == str)
+
+	// Strings are byte-reversed as in Bitcoin; e.g. the genesis block hash
+	genesis := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	g, err := NewHashFromStr(genesis)
+	assertNoError(t, err)
+	assertTrue(t, g[0] == 0x6f && g[HashSize-1] == 0x00)
+	assertTrue(t, g.String() == genesis)
+	assertFalse(t, h.IsZero())
+	assertTrue(t, Hash{}.IsZero())
+
+	// Hashes are ordered byte-wise
+	assertTrue(t, Hash{1}.Compare(Hash{2}) == -1)
+	assertTrue(t, Hash{2}.Compare(Hash{1}) == 1)
+	assertTrue(t, h.Compare(h) == 0)
+
+	// Only hex encodings of the right length are accepted
+	_, err = NewHashFromStr("ff")
+	assertTrue(t, err == ErrInvalidHashLength)
+	_, err = NewHashFromStr(str[2:] + "zz")
+	assertTrue(t, err != nil)
+
+	// Hashes are hex strings in JSON, including as map keys
+	b, err := json.Marshal(map[Hash]Hash{h: h})
+	assertNoError(t, err)
+	assertTrue(t, string(b) == `{"`+str+`":"`+str+`"}`)
+
+	decoded := map[Hash]Hash{}
+	assertNoError(t, json.Unmarshal(b, &decoded))
+	assertTrue(t, decoded[h] == h)
+}
@@ -0,0 +1,248 @@
+// Package blockchain adapts a chain of block headers to the avalanche package
+// so that blocks can be decided by consensus. A block is accepted when it is
+// part of the active chain; i.e. the valid chain with the most cumulative work.
+package blockchain
+
+import (
+	"errors"
+	"sync"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+var (
+	// ErrUnknownParent is returned when adding a header whose parent has not
+	// been added
+	ErrUnknownParent = errors.New("blockchain: unknown parent")
+
+	// ErrDuplicateHeader is returned when adding a header that was already
+	// added
+	ErrDuplicateHeader = errors.New("blockchain: duplicate header")
+
+	// ErrUnknownBlock is returned when referring to a block that has not been
+	// added
+	ErrUnknownBlock = errors.New("blockchain: unknown block")
+
+	// ErrInvalidWork is returned when adding a header with negative work
+	ErrInvalidWork = errors.New("blockchain: work must not be negative")
+)
+
+// Header is the part of a block needed to track the chain
+type Header struct {
+	// Hash is the block's id
+	Hash avalanche.Hash
+
+	// Parent is the hash of the block this one builds on
+	Parent avalanche.Hash
+
+	// Work is the amount of work done for this block alone
+	Work int64
+}
+
+// entry is a header's position in the chain
+type entry struct {
+	header   Header
+	height   int
+	work     int64
+	parent   *entry
+	children []*entry
+	invalid  bool
+
+	// seq is the order the header was added in, which breaks ties in work
+	seq int
+}
+
+// Chain is a tree of headers rooted at a genesis header. It tracks the active
+// chain and is safe for concurrent use.
+type Chain struct {
+	mu      sync.RWMutex
+	entries map[avalanche.Hash]*entry
+
+	// active holds the active chain indexed by height
+	active []*entry
+}
+
+// NewChain creates a new *Chain containing only the genesis header. The
+// genesis header's parent is ignored.
+func NewChain(genesis Header) (*Chain, error) {
+	if genesis.Work < 0 {
+		return nil, ErrInvalidWork
+	}
+
+	e := &entry{header: genesis, work: genesis.Work}
+	return &Chain{
+		entries: map[avalanche.Hash]*entry{genesis.Hash: e},
+		active:  []*entry{e},
+	}, nil
+}
+
+// AddHeader connects a header to its parent. If it gives a valid chain with
+// more cumulative work than the active chain, that chain becomes active.
+func (c *Chain) AddHeader(h Header) (*Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h.Work < 0 {
+		return nil, ErrInvalidWork
+	}
+	if _, ok := c.entries[h.Hash]; ok {
+		return nil, ErrDuplicateHeader
+	}
+
+	parent, ok := c.entries[h.Parent]
+	if !ok {
+		return nil, ErrUnknownParent
+	}
+
+	e := &entry{
+		header:  h,
+		height:  parent.height + 1,
+		work:    parent.work + h.Work,
+		parent:  parent,
+		invalid: parent.invalid,
+		seq:     len(c.entries),
+	}
+	parent.children = append(parent.children, e)
+	c.entries[h.Hash] = e
+
+	// Ties keep the chain we saw first
+	if !e.invalid && e.work > c.tip().work {
+		c.setTip(e)
+	}
+
+	return &Block{chain: c, hash: h.Hash}, nil
+}
+
+// Block returns the block with the given hash
+func (c *Chain) Block(h avalanche.Hash) (*Block, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.entries[h]; !ok {
+		return nil, ErrUnknownBlock
+	}
+	return &Block{chain: c, hash: h}, nil
+}
+
+// Tip returns the last header of the active chain
+func (c *Chain) Tip() Header {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.tip().header
+}
+
+// Height returns the height of the active chain; the genesis header is at
+// height zero
+func (c *Chain) Height() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.tip().height
+}
+
+// IsInActiveChain returns whether or not the block is part of the active chain
+func (c *Chain) IsInActiveChain(h avalanche.Hash) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.isInActiveChain(h)
+}
+
+// isInActiveChain is IsInActiveChain for callers that already hold the lock
+func (c *Chain) isInActiveChain(h avalanche.Hash) bool {
+	e, ok := c.entries[h]
+	return ok && e.height < len(c.active) && c.active[e.height] == e
+}
+
+// Invalidate marks the block and all of its descendants invalid. If the
+// active chain contained it, the valid chain with the most work becomes
+// active. The genesis header can't be invalidated.
+func (c *Chain) Invalidate(h avalanche.Hash) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[h]
+	if !ok || e.parent == nil {
+		return ErrUnknownBlock
+	}
+
+	queue := []*entry{e}
+	for len(queue) > 0 {
+		e, queue = queue[0], queue[1:]
+		e.invalid = true
+		queue = append(queue, e.children...)
+	}
+
+	if !c.tip().invalid {
+		return nil
+	}
+
+	best := c.active[0]
+	for _, e := range c.entries {
+		if !e.invalid && (e.work > best.work || e.work == best.work && e.seq < best.seq) {
+			best = e
+		}
+	}
+	c.setTip(best)
+	return nil
+}
+
+// tip returns the last entry of the active chain
+func (c *Chain) tip() *entry {
+	return c.active[len(c.active)-1]
+}
+
+// setTip makes the chain ending at the entry active
+func (c *Chain) setTip(e *entry) {
+	active := make([]*entry, e.height+1)
+	for ; e != nil; e = e.parent {
+		active[e.height] = e
+	}
+	c.active = active
+}
+
+// Block is a block in a Chain. It implements avalanche.Target.
+type Block struct {
+	chain *Chain
+	hash  avalanche.Hash
+}
+
+// Hash returns the block's id
+func (b *Block) Hash() avalanche.Hash {
+	return b.hash
+}
+
+// Type returns the Target type; in this case a block
+func (*Block) Type() string {
+	return avalanche.TypeBlock
+}
+
+// Score returns the cumulative work of the chain ending at the block
+func (b *Block) Score() int64 {
+	b.chain.mu.RLock()
+	defer b.chain.mu.RUnlock()
+
+	return b.chain.entries[b.hash].work
+}
+
+// Height returns the number of blocks between the block and genesis
+func (b *Block) Height() int {
+	b.chain.mu.RLock()
+	defer b.chain.mu.RUnlock()
+
+	return b.chain.entries[b.hash].height
+}
+
+// IsAccepted returns whether or not the block is part of the active chain
+func (b *Block) IsAccepted() bool {
+	return b.chain.IsInActiveChain(b.hash)
+}
+
+// IsValid returns whether or not the block and its ancestors are valid
+func (b *Block) IsValid() bool {
+	b.chain.mu.RLock()
+	defer b.chain.mu.RUnlock()
+
+	return !b.chain.entries[b.hash].invalid
+}
@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"testing"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+func TestChain(t *testing.T) {
+	var (
+		genesis = Header{Hash: avalanche.Hash{1}, Work: 1}
+		a       = Header{Hash: avalanche.Hash{2}, Parent: genesis.Hash, Work: 10}
+		b       = Header{Hash: avalanche.Hash{3}, Parent: a.Hash, Work: 10}
+		c       = Header{Hash: avalanche.Hash{4}, Parent: a.Hash, Work: 10}
+		d       = Header{Hash: avalanche.Hash{5}, Parent: c.Hash, Work: 10}
+	)
+
+	chain, err := NewChain(genesis)
+	assertNoError(t, err)
+
+	blockA, err := chain.AddHeader(a)
+	assertNoError(t, err)
+	blockB, err := chain.AddHeader(b)
+	assertNoError(t, err)
+	assertTip(t, chain, b)
+	assertTrue(t, blockB.Score() == 21 && blockB.Height() == 2)
+
+	// A fork with the same work does not replace the active chain
+	blockC, err := chain.AddHeader(c)
+	assertNoError(t, err)
+	assertTip(t, chain, b)
+	assertTrue(t, blockA.IsAccepted() && blockB.IsAccepted())
+	assertTrue(t, !blockC.IsAccepted())
+
+	// A fork with more work does
+	blockD, err := chain.AddHeader(d)
+	assertNoError(t, err)
+	assertTip(t, chain, d)
+	assertTrue(t, blockA.IsAccepted() && blockC.IsAccepted() && blockD.IsAccepted())
+	assertTrue(t, !blockB.IsAccepted())
+	assertTrue(t, chain.Height() == 3)
+
+	// Invalidating a block invalidates its descendants and reorgs away from them
+	assertNoError(t, chain.Invalidate(c.Hash))
+	assertTip(t, chain, b)
+	assertTrue(t, !blockC.IsValid() && !blockD.IsValid())
+	assertTrue(t, blockB.IsValid() && blockB.IsAccepted())
+
+	// Headers building on invalid blocks are invalid as well
+	e := Header{Hash: avalanche.Hash{6}, Parent: d.Hash, Work: 100}
+	blockE, err := chain.AddHeader(e)
+	assertNoError(t, err)
+	assertTrue(t, !blockE.IsValid())
+	assertTip(t, chain, b)
+
+	// Bad headers are refused
+	_, err = chain.AddHeader(b)
+	assertTrue(t, err == ErrDuplicateHeader)
+	_, err = chain.AddHeader(Header{Hash: avalanche.Hash{7}, Parent: avalanche.Hash{99}})
+	assertTrue(t, err == ErrUnknownParent)
+	_, err = chain.AddHeader(Header{Hash: avalanche.Hash{7}, Parent: b.Hash, Work: -1})
+	assertTrue(t, err == ErrInvalidWork)
+	_, err = chain.Block(avalanche.Hash{99})
+	assertTrue(t, err == ErrUnknownBlock)
+	assertTrue(t, chain.Invalidate(genesis.Hash) == ErrUnknownBlock)
+}
+
+func TestChainConsensus(t *testing.T) {
+	var (
+		genesis = Header{Hash: avalanche.Hash{1}, Work: 1}
+		a       = Header{Hash: avalanche.Hash{2}, Parent: genesis.Hash, Work: 10}
+		b       = Header{Hash: avalanche.Hash{3}, Parent: genesis.Hash, Work: 20}
+	)
+
+	chain, err := NewChain(genesis)
+	assertNoError(t, err)
+	blockA, err := chain.AddHeader(a)
+	assertNoError(t, err)
+	blockB, err := chain.AddHeader(b)
+	assertNoError(t, err)
+
+	connman := avalanche.NewConnman()
+	connman.AddNode(avalanche.NodeID(0))
+	p, err := avalanche.NewProcessor(connman, avalanche.Config{FinalizationScore: 4})
+	assertNoError(t, err)
+
+	// Blocks start out accepted if they are in the active chain
+	assertTrue(t, p.AddTargetToReconcile(blockA))
+	assertTrue(t, p.AddTargetToReconcile(blockB))
+	assertTrue(t, !p.IsAccepted(blockA) && p.IsAccepted(blockB))
+
+	// The block with the most work is polled first
+	invs := p.GetInvsForNextPoll()
+	assertTrue(t, len(invs) == 2 && invs[0].TargetHash == b.Hash && invs[0].TargetType == avalanche.TypeBlock)
+
+	// Invalid blocks are no longer polled
+	assertNoError(t, chain.Invalidate(b.Hash))
+	invs = p.GetInvsForNextPoll()
+	assertTrue(t, len(invs) == 1 && invs[0].TargetHash == a.Hash)
+}
+
+func assertTip(t *testing.T, chain *Chain, h Header) {
+	t.Helper()
+	if chain.Tip() != h {
+		t.Fatal("Expected tip", h.Hash, "but got", chain.Tip().Hash)
+	}
+}
+
+func assertTrue(t *testing.T, actual bool) {
+	t.Helper()
+	if !actual {
+		t.Fatal("Expected true; got false")
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal("Expected no error; got", err)
+	}
+}
@@ -0,0 +1,144 @@
+package avalanche
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultFinalizationScore is the confidence score we consider to be final
+	DefaultFinalizationScore = 128
+
+	// DefaultTimeStep is the amount of time to wait between event ticks
+	DefaultTimeStep = 10 * time.Millisecond
+
+	// DefaultMaxElementPoll is the maximum number of invs to send in a single
+	// query
+	DefaultMaxElementPoll = 4096
+
+	// DefaultRequestTimeout is the amount of time to wait for a response to a
+	// query
+	DefaultRequestTimeout = 1 * time.Minute
+
+	// DefaultVoteWindow is the number of most recent votes considered when
+	// deciding whether a round is conclusive; k in the Avalanche paper
+	DefaultVoteWindow = 8
+
+	// DefaultVoteQuorum is the number of votes within the window that must
+	// agree for a round to be conclusive; alpha in the Avalanche paper
+	DefaultVoteQuorum = 7
+
+	// MaxVoteWindow is the largest supported vote window
+	MaxVoteWindow = 64
+)
+
+var (
+	// ErrInvalidFinalizationScore is returned when the finalization score is out
+	// of range
+	ErrInvalidFinalizationScore = errors.New("avalanche: finalization score must be between 1 and 32767")
+
+	// ErrInvalidTimeStep is returned when the time step is not positive
+	ErrInvalidTimeStep = errors.New("avalanche: time step must be positive")
+
+	// ErrInvalidMaxElementPoll is returned when the max element poll is not
+	// positive or is more than a poll can carry on the wire
+	ErrInvalidMaxElementPoll = errors.New("avalanche: max element poll must be between 1 and 4096")
+
+	// ErrInvalidRequestTimeout is returned when the request timeout is not
+	// positive
+	ErrInvalidRequestTimeout = errors.New("avalanche: request timeout must be positive")
+
+	// ErrInvalidVoteWindow is returned when the vote window is out of range
+	ErrInvalidVoteWindow = errors.New("avalanche: vote window must be between 1 and 64")
+
+	// ErrInvalidVoteQuorum is returned when the vote quorum is not a strict
+	// majority of the vote window
+	ErrInvalidVoteQuorum = errors.New("avalanche: vote quorum must be more than half of and at most the vote window")
+)
+
+// maxFinalizationScore is the largest confidence a VoteRecord can represent;
+// the lowest bit of the confidence field is used to store acceptance
+const maxFinalizationScore = 1<<15 - 1
+
+// Config holds the tuning parameters for a Processor
+type Config struct {
+	// FinalizationScore is the confidence score we consider to be final
+	FinalizationScore int
+
+	// TimeStep is the amount of time to wait between event ticks
+	TimeStep time.Duration
+
+	// MaxElementPoll is the maximum number of invs to send in a single query.
+	// It may not exceed MaxWireElements.
+	MaxElementPoll int
+
+	// RequestTimeout is the amount of time to wait for a response to a query
+	RequestTimeout time.Duration
+
+	// VoteWindow is the number of most recent votes considered for each target
+	VoteWindow int
+
+	// VoteQuorum is the number of votes in the window that must agree for a
+	// round to be conclusive. If it is zero it defaults to 7/8 of the window.
+	VoteQuorum int
+}
+
+// DefaultConfig returns a Config populated with the default parameters
+func DefaultConfig() Config {
+	return Config{
+		FinalizationScore: DefaultFinalizationScore,
+		TimeStep:          DefaultTimeStep,
+		MaxElementPoll:    DefaultMaxElementPoll,
+		RequestTimeout:    DefaultRequestTimeout,
+		VoteWindow:        DefaultVoteWindow,
+		VoteQuorum:        DefaultVoteQuorum,
+	}
+}
+
+// withDefaults returns a copy of the Config with zero values replaced by their
+// defaults
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.FinalizationScore == 0 {
+		c.FinalizationScore = d.FinalizationScore
+	}
+	if c.TimeStep == 0 {
+		c.TimeStep = d.TimeStep
+	}
+	if c.MaxElementPoll == 0 {
+		c.MaxElementPoll = d.MaxElementPoll
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = d.RequestTimeout
+	}
+	if c.VoteWindow == 0 {
+		c.VoteWindow = d.VoteWindow
+	}
+	if c.VoteQuorum == 0 {
+		c.VoteQuorum = c.VoteWindow - c.VoteWindow/8
+	}
+	return c
+}
+
+// Validate returns an error if any of the parameters are out of range
+func (c Config) Validate() error {
+	if c.FinalizationScore < 1 || c.FinalizationScore > maxFinalizationScore {
+		return ErrInvalidFinalizationScore
+	}
+	if c.TimeStep <= 0 {
+		return ErrInvalidTimeStep
+	}
+	if c.MaxElementPoll <= 0 || c.MaxElementPoll > MaxWireElements {
+		return ErrInvalidMaxElementPoll
+	}
+	if c.RequestTimeout <= 0 {
+		return ErrInvalidRequestTimeout
+	}
+	if c.VoteWindow < 1 || c.VoteWindow > MaxVoteWindow {
+		return ErrInvalidVoteWindow
+	}
+	if c.VoteQuorum*2 <= c.VoteWindow || c.VoteQuorum > c.VoteWindow {
+		return ErrInvalidVoteQuorum
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package avalanche
+
+// ConflictingTarget is a Target that is mutually exclusive with other Targets;
+// e.g. transactions that spend the same outpoint. Only one member of each
+// conflict set can be accepted at a time.
+type ConflictingTarget interface {
+	Target
+
+	// Conflicts returns the keys of the conflict sets the Target belongs to;
+	// e.g. the outpoints spent by a transaction
+	Conflicts() []string
+}
+
+// conflictSet tracks the shared preference among mutually exclusive targets
+type conflictSet struct {
+	members   []Hash
+	successes map[Hash]int
+
+	preferred    Hash
+	hasPreferred bool
+}
+
+func newConflictSet() *conflictSet {
+	return &conflictSet{successes: map[Hash]int{}}
+}
+
+// add inserts a member into the set if it's not already present
+func (cs *conflictSet) add(h Hash) {
+	if _, ok := cs.successes[h]; ok {
+		return
+	}
+	cs.members = append(cs.members, h)
+	cs.successes[h] = 0
+}
+
+// remove takes a member out of the set and clears the preference if it was
+// the preferred member
+func (cs *conflictSet) remove(h Hash) {
+	if _, ok := cs.successes[h]; !ok {
+		return
+	}
+	delete(cs.successes, h)
+	cs.members = removeHash(cs.members, h)
+
+	if cs.hasPreferred && cs.preferred == h {
+		cs.hasPreferred = false
+	}
+}
+
+// isPreferred returns whether or not the member is the preferred one
+func (cs *conflictSet) isPreferred(h Hash) bool {
+	return cs.hasPreferred && cs.preferred == h
+}
+
+// addSuccess records a conclusive round in favor of a member. If the member
+// overtakes the currently preferred member it becomes preferred, and the
+// displaced member is returned.
+func (cs *conflictSet) addSuccess(h Hash) (displaced Hash, ok bool) {
+	cs.successes[h]++
+
+	if cs.isPreferred(h) {
+		return displaced, false
+	}
+
+	if cs.hasPreferred && cs.successes[h] <= cs.successes[cs.preferred] {
+		return displaced, false
+	}
+
+	displaced, ok = cs.preferred, cs.hasPreferred
+	cs.preferred, cs.hasPreferred = h, true
+	return displaced, ok
+}
+
+// GetConflictPreference returns the currently preferred member of a conflict
+// set, if there is one
+func (p *Processor) GetConflictPreference(key string) (Hash, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	cs, ok := p.conflictSets[key]
+	if !ok || !cs.hasPreferred {
+		return Hash{}, false
+	}
+	return cs.preferred, true
+}
+
+// addToConflictSets registers the Target with each of its conflict sets and
+// returns whether it may start out accepted
+func (p *Processor) addToConflictSets(t ConflictingTarget, accepted bool) bool {
+	keys := t.Conflicts()
+
+	for _, key := range keys {
+		cs, ok := p.conflictSets[key]
+		if !ok {
+			cs = newConflictSet()
+			p.conflictSets[key] = cs
+		}
+		cs.add(t.Hash())
+
+		// Another member already holds the preference
+		if cs.hasPreferred && cs.preferred != t.Hash() {
+			accepted = false
+		}
+	}
+
+	if accepted {
+		for _, key := range keys {
+			cs := p.conflictSets[key]
+			cs.preferred, cs.hasPreferred = t.Hash(), true
+		}
+	}
+
+	return accepted
+}
+
+// isPreferred returns whether or not the Target is preferred in all of its
+// conflict sets
+func (p *Processor) isPreferred(t ConflictingTarget) bool {
+	for _, key := range t.Conflicts() {
+		cs, ok := p.conflictSets[key]
+		if ok && !cs.isPreferred(t.Hash()) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyConflictVote updates the conflict sets of the Target after a vote has
+// been registered in its VoteRecord. Members that lose the preference are
+// rejected, and the Target is kept rejected unless it is preferred. Returns
+// whether the Target's state changed.
+func (p *Processor) applyConflictVote(t ConflictingTarget, vr *VoteRecord, changed bool,
+	updates *[]StatusUpdate) bool {
+	if vr.hasYesQuorum() {
+		for _, key := range t.Conflicts() {
+			cs, ok := p.conflictSets[key]
+			if !ok {
+				continue
+			}
+
+			if displaced, ok := cs.addSuccess(t.Hash()); ok {
+				p.rejectConflicting(displaced, updates)
+			}
+		}
+	}
+
+	if vr.isAccepted() && !p.isPreferred(t) {
+		vr.reject()
+		return false
+	}
+
+	return changed
+}
+
+// rejectConflicting moves a member that lost its preference to rejected
+func (p *Processor) rejectConflicting(h Hash, updates *[]StatusUpdate) {
+	vr, ok := p.voteRecords[h]
+	if !ok || !vr.isAccepted() {
+		return
+	}
+
+	vr.reject()
+	*updates = append(*updates, StatusUpdate{h, vr.status()})
+}
+
+// finalizeConflicts settles the conflict sets of a finalized Target. If it was
+// accepted every other member is finalized as rejected; otherwise it just
+// leaves its sets.
+func (p *Processor) finalizeConflicts(t ConflictingTarget, accepted bool, updates *[]StatusUpdate) {
+	if !accepted {
+		p.removeFromConflictSets(t)
+		return
+	}
+
+	for _, key := range t.Conflicts() {
+		cs, ok := p.conflictSets[key]
+		if !ok {
+			continue
+		}
+
+		// Copy since removing members from their sets modifies the slice
+		losers := append([]Hash{}, cs.members...)
+		for _, h := range losers {
+			if h == t.Hash() {
+				continue
+			}
+
+			if loser, ok := p.targets[h].(ConflictingTarget); ok {
+				p.removeFromConflictSets(loser)
+			}
+
+			if _, ok := p.voteRecords[h]; ok {
+				delete(p.voteRecords, h)
+				*updates = append(*updates, StatusUpdate{h, StatusInvalid})
+			}
+
+			// Anything built on a rejected vertex is rejected as well
+			if _, ok := p.dag.vertices[h]; ok {
+				p.invalidateProgeny(h, updates)
+				p.dag.remove(h)
+			}
+		}
+
+		delete(p.conflictSets, key)
+	}
+}
+
+// removeFromConflictSets takes the Target out of all of its conflict sets,
+// deleting any that become empty
+func (p *Processor) removeFromConflictSets(t ConflictingTarget) {
+	for _, key := range t.Conflicts() {
+		cs, ok := p.conflictSets[key]
+		if !ok {
+			continue
+		}
+
+		cs.remove(t.Hash())
+		if len(cs.members) == 0 {
+			delete(p.conflictSets, key)
+		}
+	}
+}
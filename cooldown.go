@@ -0,0 +1,123 @@
+package avalanche
+
+import (
+	"math"
+	"time"
+)
+
+// loadWindow is how far back polls are counted toward our load
+const loadWindow = time.Second
+
+// voteUnknown is the vote given for targets we know nothing about. It is
+// negative when read as a signed value so pollers don't consider it.
+const voteUnknown = math.MaxUint32
+
+// CooldownFunc returns how long a node that polled us should wait before
+// polling us again, given the number of polls we answered in the last second
+type CooldownFunc func(recentPolls int) time.Duration
+
+// NoCooldown never asks pollers to back off
+func NoCooldown(int) time.Duration {
+	return 0
+}
+
+// ProportionalCooldown asks pollers to wait perPoll for each poll we answered
+// in the last second, up to limit
+func ProportionalCooldown(perPoll, limit time.Duration) CooldownFunc {
+	return func(recentPolls int) time.Duration {
+		d := perPoll * time.Duration(recentPolls)
+		if d > limit || d < 0 {
+			return limit
+		}
+		return d
+	}
+}
+
+// Respond builds our Response to a poll for the given Invs. Each vote is our
+// current preference or final decision, or unknown if we have neither. The
+// cooldown is chosen by the Processor's CooldownFunc from our recent load. The
+// Response is signed if the Processor has an identity key. An error is
+// returned if it cannot be signed; e.g. because it has too many votes to be
+// encoded. Peers that know our key would reject it unsigned, so it should not
+// be sent.
+func (p *Processor) Respond(round int64, invs []Inv) (Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	votes := make([]Vote, len(invs))
+	for i, inv := range invs {
+		votes[i] = NewVote(p.preference(inv.TargetHash), inv.TargetHash)
+	}
+
+	resp := NewResponse(round, p.nextCooldown(), votes)
+	if p.identityKey == nil {
+		return resp, nil
+	}
+
+	signed, err := resp.Sign(p.identityKey)
+	if err != nil {
+		p.logger.Error("failed to sign response", "round", round, "invs", len(invs), "err", err)
+		return Response{}, err
+	}
+	return signed, nil
+}
+
+// preference returns our vote for the target with the given hash
+func (p *Processor) preference(h Hash) uint32 {
+	vr, ok := p.voteRecords[h]
+	if !ok {
+		if accepted, ok := p.finalized[h]; ok {
+			return boolToUint32(!accepted)
+		}
+		return voteUnknown
+	}
+
+	if !vr.isAccepted() {
+		return 1
+	}
+
+	// Vertices are only voted for if their ancestors are preferred too
+	for _, ancestor := range p.dag.ancestors(h) {
+		if avr, ok := p.voteRecords[ancestor]; ok && !avr.isAccepted() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// nextCooldown records a poll being answered and returns the cooldown, in
+// milliseconds, to advertise in the response to it
+func (p *Processor) nextCooldown() uint32 {
+	now := p.now()
+
+	// Forget polls that are no longer part of our load
+	recent := p.recentPolls[:0]
+	for _, t := range p.recentPolls {
+		if now.Sub(t) < loadWindow {
+			recent = append(recent, t)
+		}
+	}
+	p.recentPolls = append(recent, now)
+
+	ms := p.cooldownFunc(len(p.recentPolls)) / time.Millisecond
+	if ms > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(ms)
+}
+
+// isCoolingDown returns whether or not the node asked us to wait before
+// polling it again and that time has not yet passed
+func (p *Processor) isCoolingDown(id NodeID, now time.Time) bool {
+	until, ok := p.cooldowns[id]
+	return ok && now.Before(until)
+}
+
+// recordCooldown remembers the cooldown the node advertised in its Response
+func (p *Processor) recordCooldown(id NodeID, resp Response) {
+	if resp.GetCooldown() == 0 {
+		delete(p.cooldowns, id)
+		return
+	}
+	p.cooldowns[id] = p.now().Add(resp.GetCooldownDuration())
+}
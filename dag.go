@@ -0,0 +1,253 @@
+package avalanche
+
+// DAGTarget is a Target that is a vertex in a DAG; e.g. a transaction that
+// spends the outputs of other transactions. A vote for a DAGTarget is also a
+// vote for all of its ancestors.
+type DAGTarget interface {
+	Target
+
+	// Parents returns the hashes of the Targets this Target directly depends on
+	Parents() []Hash
+}
+
+// vertex is a DAGTarget's position in the DAG
+type vertex struct {
+	parents  []Hash
+	children []Hash
+
+	// chit is set once the vertex has had a conclusive round of yes votes
+	chit bool
+}
+
+// dag tracks the ancestry of undecided DAGTargets. Finalized vertices are
+// removed, and parents that are not in the DAG are considered accepted.
+type dag struct {
+	vertices map[Hash]*vertex
+
+	// orphans maps parents we have not seen yet to their known children
+	orphans map[Hash][]Hash
+}
+
+func newDAG() *dag {
+	return &dag{
+		vertices: map[Hash]*vertex{},
+		orphans:  map[Hash][]Hash{},
+	}
+}
+
+// add inserts a vertex and links it to its parents and any known children
+func (d *dag) add(h Hash, parents []Hash) {
+	if _, ok := d.vertices[h]; ok {
+		return
+	}
+
+	v := &vertex{parents: parents, children: d.orphans[h]}
+	delete(d.orphans, h)
+	d.vertices[h] = v
+
+	for _, parent := range parents {
+		if pv, ok := d.vertices[parent]; ok {
+			pv.children = append(pv.children, h)
+			continue
+		}
+		d.orphans[parent] = append(d.orphans[parent], h)
+	}
+}
+
+// remove deletes a vertex and unlinks it from its parents. Its children keep
+// their reference to it so they can be relinked if it is added again.
+func (d *dag) remove(h Hash) {
+	v, ok := d.vertices[h]
+	if !ok {
+		return
+	}
+	delete(d.vertices, h)
+
+	for _, parent := range v.parents {
+		if pv, ok := d.vertices[parent]; ok {
+			pv.children = removeHash(pv.children, h)
+			continue
+		}
+
+		d.orphans[parent] = removeHash(d.orphans[parent], h)
+		if len(d.orphans[parent]) == 0 {
+			delete(d.orphans, parent)
+		}
+	}
+
+	if len(v.children) > 0 {
+		d.orphans[h] = v.children
+	}
+}
+
+// ancestors returns all vertices reachable through parent links, excluding
+// the vertex itself
+func (d *dag) ancestors(h Hash) []Hash {
+	return d.walk(h, func(v *vertex) []Hash { return v.parents })
+}
+
+// progeny returns all vertices reachable through child links, excluding the
+// vertex itself
+func (d *dag) progeny(h Hash) []Hash {
+	return d.walk(h, func(v *vertex) []Hash { return v.children })
+}
+
+// walk does a breadth-first traversal of the DAG starting from the given
+// vertex and following the edges returned by next
+func (d *dag) walk(h Hash, next func(*vertex) []Hash) []Hash {
+	v, ok := d.vertices[h]
+	if !ok {
+		return nil
+	}
+
+	var (
+		found = []Hash{}
+		seen  = map[Hash]struct{}{h: {}}
+		queue = next(v)
+	)
+
+	for len(queue) > 0 {
+		h, queue = queue[0], queue[1:]
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+
+		v, ok := d.vertices[h]
+		if !ok {
+			continue
+		}
+
+		found = append(found, h)
+		queue = append(queue, next(v)...)
+	}
+
+	return found
+}
+
+// hasChildren returns whether or not a vertex has any undecided children
+func (d *dag) hasChildren(h Hash) bool {
+	v, ok := d.vertices[h]
+	return ok && len(v.children) > 0
+}
+
+// parentsDecided returns whether or not all of a vertex's parents have left
+// the DAG; i.e. they are finalized or were never being decided
+func (d *dag) parentsDecided(h Hash) bool {
+	v, ok := d.vertices[h]
+	if !ok {
+		return true
+	}
+
+	for _, parent := range v.parents {
+		if _, ok := d.vertices[parent]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// GetDAGConfidence returns the confidence in a DAGTarget computed over its
+// progeny; i.e. the number of undecided vertices, including itself, that
+// descend from it and have had a conclusive round of yes votes
+func (p *Processor) GetDAGConfidence(t DAGTarget) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	v, ok := p.dag.vertices[t.Hash()]
+	if !ok {
+		return 0
+	}
+
+	confidence := 0
+	if v.chit {
+		confidence++
+	}
+	for _, h := range p.dag.progeny(t.Hash()) {
+		if p.dag.vertices[h].chit {
+			confidence++
+		}
+	}
+	return confidence
+}
+
+// IsStronglyPreferred returns whether or not the DAGTarget and all of its
+// undecided ancestors are currently preferred. This is how a node should
+// respond when queried about a DAGTarget.
+func (p *Processor) IsStronglyPreferred(t DAGTarget) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.isAccepted(t) {
+		return false
+	}
+
+	for _, h := range p.dag.ancestors(t.Hash()) {
+		vr, ok := p.voteRecords[h]
+		if ok && !vr.isAccepted() {
+			return false
+		}
+	}
+	return true
+}
+
+// withTransitiveVotes returns the votes with an added yes vote for each
+// ancestor of a DAGTarget that received a yes vote. Ancestors that were voted
+// on directly keep their own vote. Implied votes come first so that ancestors
+// are settled before their descendants.
+func (p *Processor) withTransitiveVotes(votes []Vote) []Vote {
+	if len(p.dag.vertices) == 0 {
+		return votes
+	}
+
+	voted := make(map[Hash]struct{}, len(votes))
+	for _, v := range votes {
+		voted[v.GetHash()] = struct{}{}
+	}
+
+	implied := []Vote{}
+	for _, v := range votes {
+		if v.GetError() != 0 {
+			continue
+		}
+
+		// Walk back from the most distant ancestors
+		ancestors := p.dag.ancestors(v.GetHash())
+		for i := len(ancestors) - 1; i >= 0; i-- {
+			h := ancestors[i]
+			if _, ok := voted[h]; ok {
+				continue
+			}
+			voted[h] = struct{}{}
+			implied = append(implied, NewVote(0, h))
+		}
+	}
+
+	return append(implied, votes...)
+}
+
+// invalidateProgeny finalizes the rejection of all undecided descendants of a
+// rejected vertex and removes them from the DAG
+func (p *Processor) invalidateProgeny(h Hash, updates *[]StatusUpdate) {
+	for _, child := range p.dag.progeny(h) {
+		if _, ok := p.voteRecords[child]; ok {
+			delete(p.voteRecords, child)
+			*updates = append(*updates, StatusUpdate{child, StatusInvalid})
+		}
+
+		if ct, ok := p.targets[child].(ConflictingTarget); ok {
+			p.removeFromConflictSets(ct)
+		}
+
+		p.dag.remove(child)
+	}
+}
+
+func removeHash(hashes []Hash, h Hash) []Hash {
+	for i, other := range hashes {
+		if other == h {
+			return append(hashes[:i], hashes[i+1:]...)
+		}
+	}
+	return hashes
+}
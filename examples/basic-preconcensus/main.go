@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"log"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,23 +18,21 @@ const (
 	txCount   = 1e2
 )
 
-var (
-	networkNodes   []*node
-	loggingEnabled = true
-)
+var networkNodes []*node
 
 func main() {
 	logging := flag.Bool("logging", false, "Enable logging")
 	flag.Parse()
 
-	if logging != nil {
-		loggingEnabled = *logging
+	opts := []avalanche.Option{}
+	if *logging {
+		opts = append(opts, avalanche.WithLogger(stdLogger{}))
 	}
 
 	// Create nodes
 	networkNodes = make([]*node, nodeCount)
 	for i := 0; i < nodeCount; i++ {
-		networkNodes[i] = newNode(avalanche.NodeID(i), avalanche.NewConnman())
+		networkNodes[i] = newNode(avalanche.NodeID(i), avalanche.NewConnman(), opts...)
 	}
 
 	// Create wg with a slot for each node
@@ -48,7 +49,7 @@ func main() {
 	// Send txs to each node
 	for _, t := range rand.Perm(txCount) {
 		for i := 0; i < nodeCount; i++ {
-			networkNodes[i].incoming <- &tx{hash: int64(t), isAccepted: true}
+			networkNodes[i].incoming <- &tx{hash: txHash(t), isAccepted: true}
 		}
 	}
 
@@ -61,28 +62,43 @@ func main() {
 	wg.Wait()
 
 	fmt.Println(fmt.Sprintf("Finished in %fs", time.Now().Sub(t0).Seconds()))
-	log("Nodes fully finalized: %d", nodesFullyFinalized)
+	fmt.Println(fmt.Sprintf("Nodes fully finalized: %d", nodesFullyFinalized))
 }
 
-func log(str string, args ...interface{}) {
-	if loggingEnabled {
-		fmt.Println(fmt.Sprintf(str, args...))
+// stdLogger is an avalanche.Logger that prints with the standard library's
+// log package. Debug messages are discarded since every poll is one.
+type stdLogger struct{}
+
+func (stdLogger) Debug(string, ...interface{})          {}
+func (stdLogger) Info(msg string, args ...interface{})  { printLog("INFO", msg, args) }
+func (stdLogger) Warn(msg string, args ...interface{})  { printLog("WARN", msg, args) }
+func (stdLogger) Error(msg string, args ...interface{}) { printLog("ERROR", msg, args) }
+
+func printLog(level, msg string, args []interface{}) {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s", level, msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(b, " %v=%v", args[i], args[i+1])
 	}
+	log.Println(b.String())
 }
 
 type node struct {
-	id         avalanche.NodeID
-	snowball   *avalanche.Processor
-	snowballMu *sync.RWMutex
-	incoming   chan (*tx)
+	id       avalanche.NodeID
+	snowball *avalanche.Processor
+	incoming chan (*tx)
 }
 
-func newNode(id avalanche.NodeID, connman *avalanche.Connman) *node {
+func newNode(id avalanche.NodeID, connman *avalanche.Connman, opts ...avalanche.Option) *node {
+	snowball, err := avalanche.NewProcessor(connman, avalanche.DefaultConfig(), opts...)
+	if err != nil {
+		panic(err)
+	}
+
 	return &node{
-		id:         id,
-		snowball:   avalanche.NewProcessor(connman),
-		snowballMu: &sync.RWMutex{},
-		incoming:   make(chan (*tx), 10),
+		id:       id,
+		snowball: snowball,
+		incoming: make(chan (*tx), 10),
 	}
 }
 
@@ -98,9 +114,7 @@ func (n node) run(wg *sync.WaitGroup) {
 	doneAdding := make(chan (struct{}))
 	go func() {
 		for t := range n.incoming {
-			n.snowballMu.Lock()
 			n.snowball.AddTargetToReconcile(t)
-			n.snowballMu.Unlock()
 		}
 		close(doneAdding)
 	}()
@@ -119,9 +133,7 @@ func (n node) run(wg *sync.WaitGroup) {
 		updates := []avalanche.StatusUpdate{}
 
 		// Query node
-		n.snowballMu.Lock()
-		invs := n.snowball.GetInvsForNextPoll()
-		n.snowballMu.Unlock()
+		round, invs := n.snowball.StartQuery(avalanche.NodeID(nodeID))
 
 		// All done
 		// if len(invs) == 0 {
@@ -129,30 +141,26 @@ func (n node) run(wg *sync.WaitGroup) {
 		// 	return
 		// }
 
-		resp := networkNodes[nodeID].query(invs)
+		// Nothing was polled so there is no response to register
+		if len(invs) == 0 {
+			continue
+		}
 
-		// Register query response
-		n.snowballMu.Lock()
-		n.snowball.RegisterVotes(n.id, resp, &updates)
-		n.snowballMu.Unlock()
+		resp, err := networkNodes[nodeID].query(round, invs)
+		if err != nil {
+			continue
+		}
 
-		if len(updates) == 0 {
+		// Register query response. The processor logs invalid responses and
+		// status changes itself.
+		err = n.snowball.RegisterVotes(avalanche.NodeID(nodeID), resp, &updates)
+		if err != nil {
 			continue
 		}
 
 		for _, update := range updates {
 			if update.Status == avalanche.StatusFinalized {
 				finalizedCount++
-				log("Finalized tx %d on node %d after %d queries", update.Hash, n.id, queries)
-			} else if update.Status == avalanche.StatusAccepted {
-				log("Accepted tx %d on node %d after %d queries", update.Hash, n.id, queries)
-			} else if update.Status == avalanche.StatusRejected {
-				log("Rejected tx %d on node %d after %d queries", update.Hash, n.id, queries)
-			} else if update.Status == avalanche.StatusInvalid {
-				log("Invalidated tx %d on node %d after %d queries", update.Hash, n.id, queries)
-			} else {
-				fmt.Println(update.Status == avalanche.StatusAccepted)
-				panic(update)
 			}
 		}
 
@@ -162,48 +170,36 @@ func (n node) run(wg *sync.WaitGroup) {
 		}
 	}
 
-	log("Limit exceeded")
+	fmt.Println(fmt.Sprintf("Limit exceeded on node %d after %d queries", n.id, queries))
 }
 
-func (n node) query(invs []avalanche.Inv) avalanche.Response {
-	n.snowballMu.Lock()
-	defer n.snowballMu.Unlock()
-
-	votes := make([]avalanche.Vote, len(invs))
-
+func (n node) query(round int64, invs []avalanche.Inv) (avalanche.Response, error) {
 	for i := 0; i < len(invs); i++ {
-		t := &tx{hash: int64(invs[i].TargetHash), isAccepted: true}
-
-		n.snowball.AddTargetToReconcile(t)
-
-		var vote uint32 = 0
-		if !n.snowball.IsAccepted(t) {
-			vote = 1
-		}
-
-		// Randomly flip votes to prolong convergence
-		// if rand.Float64()*100 < 35 {
-		// 	vote = vote ^ 1
-		// }
-
-		votes[i] = avalanche.NewVote(vote, invs[i].TargetHash)
+		n.snowball.AddTargetToReconcile(&tx{hash: invs[i].TargetHash, isAccepted: true})
 	}
 
-	return avalanche.NewResponse(0, 0, votes)
+	return n.snowball.Respond(round, invs)
 }
 
 // tx
 type tx struct {
-	hash       int64
+	hash       avalanche.Hash
 	isAccepted bool
 }
 
-func (t *tx) Hash() avalanche.Hash { return avalanche.Hash(t.hash) }
+// txHash returns a Hash with the number encoded into its first bytes
+func txHash(i int) avalanche.Hash {
+	h := avalanche.Hash{}
+	binary.LittleEndian.PutUint64(h[:], uint64(i))
+	return h
+}
+
+func (t *tx) Hash() avalanche.Hash { return t.hash }
 
 func (t *tx) IsAccepted() bool { return t.isAccepted }
 
 func (*tx) IsValid() bool { return true }
 
-func (*tx) Type() string { return "tx" }
+func (*tx) Type() string { return avalanche.TypeTx }
 
 func (*tx) Score() int64 { return 1 }
@@ -0,0 +1,76 @@
+package avalanche
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+)
+
+// HashSize is the number of bytes in a Hash
+const HashSize = 32
+
+// ErrInvalidHashLength is returned when parsing a hex string that does not
+// encode exactly HashSize bytes
+var ErrInvalidHashLength = errors.New("avalanche: hash must be 32 bytes")
+
+// Hash is a unique digest that represents a Target; e.g. a transaction id or
+// block hash
+type Hash [HashSize]byte
+
+// NewHashFromStr parses a Hash from its hex encoding. Like Bitcoin, the string
+// is in byte-reversed order; e.g. the hashes shown by a node or block explorer.
+func NewHashFromStr(s string) (Hash, error) {
+	h := Hash{}
+	if hex.DecodedLen(len(s)) != HashSize {
+		return h, ErrInvalidHashLength
+	}
+
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, err
+	}
+
+	return h.reversed(), nil
+}
+
+// String returns the hex encoding of the Hash in byte-reversed order, as
+// Bitcoin displays hashes
+func (h Hash) String() string {
+	reversed := h.reversed()
+	return hex.EncodeToString(reversed[:])
+}
+
+// reversed returns a copy of the Hash with its bytes in reverse order
+func (h Hash) reversed() Hash {
+	for i := 0; i < HashSize/2; i++ {
+		h[i], h[HashSize-1-i] = h[HashSize-1-i], h[i]
+	}
+	return h
+}
+
+// Compare returns -1, 0, or 1 if the Hash is less than, equal to, or greater
+// than the other Hash when compared byte-wise
+func (h Hash) Compare(other Hash) int {
+	return bytes.Compare(h[:], other[:])
+}
+
+// IsZero returns whether or not every byte of the Hash is zero
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. This also makes
+// a Hash encode as a hex string in JSON.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (h *Hash) UnmarshalText(text []byte) error {
+	parsed, err := NewHashFromStr(string(text))
+	if err != nil {
+		return err
+	}
+
+	*h = parsed
+	return nil
+}
@@ -0,0 +1,34 @@
+package avalanche
+
+// Logger records structured messages. Each message is followed by alternating
+// keys and values; e.g. Info("finalized", "hash", h, "accepted", true). It is
+// satisfied by *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger is a Logger that discards everything. It is used when no Logger
+// is given.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// logUpdates records state flips and finalizations. The node and round are
+// those of the Response that caused them.
+func (p *Processor) logUpdates(id NodeID, round int64, updates []StatusUpdate) {
+	for _, u := range updates {
+		switch u.Status {
+		case StatusAccepted, StatusRejected:
+			p.logger.Info("status changed", "hash", u.Hash, "status", u.Status.String(), "node", id, "round", round)
+		case StatusFinalized, StatusInvalid:
+			p.logger.Info("finalized", "hash", u.Hash, "accepted", u.Status == StatusFinalized,
+				"node", id, "round", round)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package avalanche
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var (
+		connman = NewConnman()
+		logger  = &testLogger{}
+		nodeID  = NodeID(3)
+		updates = []StatusUpdate{}
+		tx      = &testTx{hash: Hash{1}}
+	)
+	connman.SetLogger(logger)
+	connman.AddNode(nodeID)
+	assertTrue(t, logger.has("debug", "node connected", "node", nodeID))
+
+	p, err := NewProcessor(connman, Config{FinalizationScore: 8}, WithLogger(logger))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	round := p.GetRound()
+	for len(updates) == 0 || updates[len(updates)-1].Status != StatusFinalized {
+		assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{tx.hash: 0}, &updates))
+	}
+	assertTrue(t, logger.has("debug", "sending poll", "node", nodeID, "round", round))
+	assertTrue(t, logger.has("info", "status changed", "hash", tx.hash, "status", "accepted", "node", nodeID))
+	assertTrue(t, logger.has("info", "finalized", "hash", tx.hash, "accepted", true, "node", nodeID))
+
+	bogus := NewResponse(p.GetRound()+1, 0, []Vote{})
+	err = p.RegisterVotes(nodeID, bogus, &updates)
+	assertTrue(t, logger.has("warn", "rejected response", "node", nodeID, "round", p.GetRound()+1, "err", err))
+
+	assertTrue(t, connman.RemoveNode(nodeID))
+	assertTrue(t, logger.has("debug", "node disconnected", "node", nodeID))
+}
+
+type testLogEntry struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+// testLogger records every entry it is given
+type testLogger struct {
+	mu      sync.Mutex
+	entries []testLogEntry
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, testLogEntry{level, msg, args})
+}
+
+// has returns whether or not an entry was logged with the level and message
+// and at least the given key/value pairs
+func (l *testLogger) has(level, msg string, kvs ...interface{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.entries {
+		if e.level == level && e.msg == msg && hasArgs(e.args, kvs) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasArgs(args, kvs []interface{}) bool {
+	for i := 0; i+1 < len(kvs); i += 2 {
+		found := false
+		for j := 0; j+1 < len(args); j += 2 {
+			if args[j] == kvs[i] && args[j+1] == kvs[i+1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,355 @@
+package avalanche
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets of
+// the time-to-accept and time-to-finalize histograms
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metricsContentType is the content type of the Prometheus text exposition
+// format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// voteLabels names the values a vote can have
+var voteLabels = [...]string{"yes", "no", "unknown"}
+
+// statusLabels names the Statuses in the order of their values
+var statusLabels = [...]Status{StatusInvalid, StatusRejected, StatusAccepted, StatusFinalized}
+
+// Metrics collects counters and histograms about a Processor and its Connman
+// and serves them in the Prometheus text exposition format. It implements
+// http.Handler. A Metrics should only be given to a single Processor. All
+// methods are safe for concurrent use.
+type Metrics struct {
+	mu sync.Mutex
+
+	pollsSent         uint64
+	pollFailures      uint64
+	responses         uint64
+	rejectedResponses uint64
+	timeouts          uint64
+	votes             [len(voteLabels)]uint64
+	transitions       [len(statusLabels)]uint64
+	connects          uint64
+	disconnects       uint64
+
+	timeToAccept   *histogram
+	timeToFinalize *histogram
+
+	// gauges are read when the metrics are written
+	gauges []gauge
+}
+
+// NewMetrics creates a new *Metrics whose histograms use the given buckets,
+// in seconds. If none are given DefaultLatencyBuckets are used.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	return &Metrics{
+		timeToAccept:   newHistogram(buckets),
+		timeToFinalize: newHistogram(buckets),
+	}
+}
+
+// gauge is a value that is read when the metrics are written
+type gauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// addGauge registers a value to be read when the metrics are written
+func (m *Metrics) addGauge(name, help string, value func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gauges = append(m.gauges, gauge{name, help, value})
+}
+
+// trackProcessor registers the gauges of a Processor
+func (m *Metrics) trackProcessor(p *Processor) {
+	m.addGauge("avalanche_targets", "Targets that are being voted on.", func() float64 {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return float64(len(p.voteRecords))
+	})
+	m.addGauge("avalanche_pending_queries", "Queries waiting on a response.", func() float64 {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return float64(len(p.queries))
+	})
+	m.addGauge("avalanche_round", "Round of the next query.", func() float64 {
+		return float64(p.GetRound())
+	})
+}
+
+// trackConnman registers the gauges of a Connman and counts its node events
+// until the returned function is called
+func (m *Metrics) trackConnman(c *Connman) (unsubscribe func()) {
+	m.addGauge("avalanche_nodes", "Nodes that can be polled.", func() float64 {
+		return float64(len(c.NodesIDs()))
+	})
+
+	return c.Subscribe(func(e NodeEvent) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		switch e.Type {
+		case NodeConnected:
+			m.connects++
+		case NodeDisconnected:
+			m.disconnects++
+		}
+	})
+}
+
+// The methods below record events. They do nothing on a nil *Metrics so that
+// a Processor without metrics can call them unconditionally.
+
+func (m *Metrics) pollSent() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pollsSent++
+}
+
+func (m *Metrics) pollFailed() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pollFailures++
+}
+
+func (m *Metrics) queryTimedOut() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.timeouts++
+}
+
+// responseReceived counts a Response and, if it was valid, its votes
+func (m *Metrics) responseReceived(resp Response, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.rejectedResponses++
+		return
+	}
+
+	m.responses++
+	for _, v := range resp.GetVotes() {
+		switch v.GetError() {
+		case 0:
+			m.votes[0]++
+		case 1:
+			m.votes[1]++
+		default:
+			m.votes[2]++
+		}
+	}
+}
+
+func (m *Metrics) statusChanged(s Status) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if int(s) >= 0 && int(s) < len(m.transitions) {
+		m.transitions[s]++
+	}
+}
+
+func (m *Metrics) accepted(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.timeToAccept.observe(d.Seconds())
+}
+
+func (m *Metrics) finalized(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.timeToFinalize.observe(d.Seconds())
+}
+
+// ServeHTTP implements the http.Handler interface by writing the metrics in
+// the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	_ = m.WriteText(w)
+}
+
+// WriteText writes the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) error {
+	// Gauges take other locks so they are read before taking ours
+	m.mu.Lock()
+	gauges := append([]gauge{}, m.gauges...)
+	m.mu.Unlock()
+
+	values := make([]float64, len(gauges))
+	for i, g := range gauges {
+		values[i] = g.value()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+
+	writeCounter(buf, "avalanche_polls_sent_total", "Queries started.", m.pollsSent)
+	writeCounter(buf, "avalanche_poll_failures_total", "Queries the transport failed to send.", m.pollFailures)
+	writeCounter(buf, "avalanche_responses_total", "Valid responses registered.", m.responses)
+	writeCounter(buf, "avalanche_rejected_responses_total", "Responses rejected as invalid.", m.rejectedResponses)
+	writeCounter(buf, "avalanche_query_timeouts_total", "Queries that expired without a response.", m.timeouts)
+
+	writeHeader(buf, "avalanche_votes_total", "Votes received in valid responses.", "counter")
+	for i, label := range voteLabels {
+		fmt.Fprintf(buf, "avalanche_votes_total{vote=%q} %d\n", label, m.votes[i])
+	}
+
+	writeHeader(buf, "avalanche_status_transitions_total", "Status changes of Targets.", "counter")
+	for i, s := range statusLabels {
+		fmt.Fprintf(buf, "avalanche_status_transitions_total{status=%q} %d\n", s, m.transitions[i])
+	}
+
+	writeCounter(buf, "avalanche_node_connections_total", "Nodes added to the Connman.", m.connects)
+	writeCounter(buf, "avalanche_node_disconnections_total", "Nodes removed from the Connman.", m.disconnects)
+
+	m.timeToAccept.write(buf, "avalanche_time_to_accept_seconds",
+		"Time from adding a rejected Target until it is first accepted.")
+	m.timeToFinalize.write(buf, "avalanche_time_to_finalize_seconds",
+		"Time from adding a Target until consensus on it is finalized.")
+
+	for i, g := range gauges {
+		writeHeader(buf, g.name, g.help, "gauge")
+		fmt.Fprintf(buf, "%s %s\n", g.name, formatFloat(values[i]))
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounter(w io.Writer, name, help string, value uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// histogram counts observations in cumulative buckets
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	bounds = append([]float64{}, bounds...)
+	sort.Float64s(bounds)
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	writeHeader(w, name, help, "histogram")
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// targetTiming is when a Target was added and whether it has been accepted
+// since, for the latency histograms
+type targetTiming struct {
+	added    time.Time
+	accepted bool
+}
+
+// startTiming begins timing a Target that was added to reconcile
+func (p *Processor) startTiming(h Hash, accepted bool) {
+	if p.metrics == nil {
+		return
+	}
+	p.timings[h] = &targetTiming{added: p.now(), accepted: accepted}
+}
+
+// observeUpdates records the StatusUpdates in the metrics
+func (p *Processor) observeUpdates(updates []StatusUpdate) {
+	if p.metrics == nil {
+		return
+	}
+
+	now := p.now()
+	for _, u := range updates {
+		p.metrics.statusChanged(u.Status)
+
+		timing, ok := p.timings[u.Hash]
+		if !ok {
+			continue
+		}
+
+		switch u.Status {
+		case StatusAccepted:
+			if !timing.accepted {
+				timing.accepted = true
+				p.metrics.accepted(now.Sub(timing.added))
+			}
+		case StatusFinalized, StatusInvalid:
+			if u.Status == StatusFinalized && !timing.accepted {
+				p.metrics.accepted(now.Sub(timing.added))
+			}
+			p.metrics.finalized(now.Sub(timing.added))
+			delete(p.timings, u.Hash)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package avalanche
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	defer func(c clocker) { clock = c }(clock)
+	now := time.Now()
+	clock = stubClocker{now}
+
+	var (
+		connman = NewConnman()
+		metrics = NewMetrics(1, 10, 100)
+		nodeID  = NodeID(0)
+		updates = []StatusUpdate{}
+		tx      = &testTx{hash: Hash{1}}
+	)
+	p, err := NewProcessor(connman, Config{FinalizationScore: 8}, WithMetrics(metrics))
+	assertNoError(t, err)
+	connman.AddNode(nodeID)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	// Vote yes once a second until the rejected tx is accepted and finalized
+	polls := 0
+	for len(updates) == 0 || updates[len(updates)-1].Status != StatusFinalized {
+		clock = stubClocker{now.Add(time.Duration(polls) * time.Second)}
+		assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{tx.hash: 0}, &updates))
+		polls++
+		assertTrue(t, polls < 100)
+	}
+	assertTrue(t, len(updates) == 2 && updates[0].Status == StatusAccepted)
+
+	bogus := NewResponse(p.GetRound()+1, 0, []Vote{})
+	assertResponseError(t, ErrUnknownQuery, p.RegisterVotes(nodeID, bogus, &updates))
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assertTrue(t, w.Header().Get("Content-Type") == metricsContentType)
+
+	lines := map[string]struct{}{}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		lines[line] = struct{}{}
+	}
+
+	for _, expected := range []string{
+		"# TYPE avalanche_polls_sent_total counter",
+		"avalanche_polls_sent_total " + strconv.Itoa(polls),
+		"avalanche_responses_total " + strconv.Itoa(polls),
+		"avalanche_rejected_responses_total 1",
+		"avalanche_query_timeouts_total 0",
+		`avalanche_votes_total{vote="yes"} ` + strconv.Itoa(polls),
+		`avalanche_votes_total{vote="no"} 0`,
+		`avalanche_status_transitions_total{status="accepted"} 1`,
+		`avalanche_status_transitions_total{status="finalized"} 1`,
+		"avalanche_node_connections_total 1",
+		"# TYPE avalanche_time_to_finalize_seconds histogram",
+		`avalanche_time_to_finalize_seconds_bucket{le="1"} 0`,
+		`avalanche_time_to_finalize_seconds_bucket{le="100"} 1`,
+		`avalanche_time_to_finalize_seconds_bucket{le="+Inf"} 1`,
+		"avalanche_time_to_finalize_seconds_sum " + strconv.Itoa(polls-1),
+		"avalanche_time_to_finalize_seconds_count 1",
+		"avalanche_time_to_accept_seconds_count 1",
+		"# TYPE avalanche_targets gauge",
+		"avalanche_targets 0",
+		"avalanche_nodes 1",
+	} {
+		if _, ok := lines[expected]; !ok {
+			t.Fatalf("Missing line %q in:\n%s", expected, w.Body.String())
+		}
+	}
+
+	// Without metrics nothing is recorded
+	p = newTestProcessor(t, NewConnman())
+	assertTrue(t, p.metrics == nil)
+	p.observeUpdates(updates)
+}
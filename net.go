@@ -1,31 +1,210 @@
 package avalanche
 
+import (
+	"crypto/ed25519"
+	"sync"
+	"time"
+)
+
+// DefaultNodeWeight is the weight given to nodes added without one
+const DefaultNodeWeight = 1
+
+// NodeInfo is the metadata kept about a node
+type NodeInfo struct {
+	// ID is the identifier for the node
+	ID NodeID
+
+	// Address is the network address of the node
+	Address string
+
+	// Weight is the node's chance of being polled relative to other nodes;
+	// e.g. its stake
+	Weight uint64
+
+	// Inbound is true if the node connected to us rather than us to it
+	Inbound bool
+
+	// PublicKey is the node's identity key, which its responses are signed
+	// with
+	PublicKey ed25519.PublicKey
+
+	// LastSeen is when we last received a valid message from the node
+	LastSeen time.Time
+}
+
+// NodeEventType is the kind of change to the set of nodes
+type NodeEventType int
+
+const (
+	// NodeConnected means a node was added
+	NodeConnected NodeEventType = iota
+
+	// NodeDisconnected means a node was removed
+	NodeDisconnected
+)
+
+// NodeEvent describes a node being added or removed
+type NodeEvent struct {
+	Type NodeEventType
+	Node NodeInfo
+}
+
 type node struct {
-	id NodeID
+	info NodeInfo
 }
 
-func newNode(id NodeID) *node {
-	return &node{id: id}
+func newNode(info NodeInfo) *node {
+	return &node{info: info}
 }
 
+// Connman keeps track of the nodes we can poll. It is safe for concurrent use.
 type Connman struct {
-	nodes map[NodeID]*node
+	mu     sync.RWMutex
+	nodes  map[NodeID]*node
+	logger Logger
+
+	// eventMu serializes adding and removing nodes with the delivery of the
+	// resulting events, and guards the subscribers
+	eventMu          sync.Mutex
+	subscribers      map[int]func(NodeEvent)
+	nextSubscriberID int
 }
 
+// NewConnman creates a new *Connman with no nodes
 func NewConnman() *Connman {
 	return &Connman{
-		nodes: map[NodeID]*node{},
+		nodes:       map[NodeID]*node{},
+		logger:      nopLogger{},
+		subscribers: map[int]func(NodeEvent){},
 	}
 }
 
+// SetLogger has the Connman record nodes being added and removed with the
+// given Logger. By default nothing is logged.
+func (c *Connman) SetLogger(l Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger = l
+}
+
+// AddNode adds a node with the default weight
 func (c *Connman) AddNode(id NodeID) {
-	c.nodes[id] = newNode(id)
+	c.AddNodeWithWeight(id, DefaultNodeWeight)
+}
+
+// AddNodeWithWeight adds a node whose chance of being polled is proportional
+// to the given weight; e.g. its stake
+func (c *Connman) AddNodeWithWeight(id NodeID, weight uint64) {
+	c.AddNodeWithInfo(NodeInfo{ID: id, Weight: weight})
+}
+
+// AddNodeWithInfo adds a node with the given metadata. If the node already
+// exists its metadata is replaced.
+func (c *Connman) AddNodeWithInfo(info NodeInfo) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+
+	c.mu.Lock()
+	_, exists := c.nodes[info.ID]
+	c.nodes[info.ID] = newNode(info)
+	logger := c.logger
+	c.mu.Unlock()
+
+	if !exists {
+		logger.Debug("node connected", "node", info.ID, "address", info.Address, "weight", info.Weight,
+			"inbound", info.Inbound)
+		c.notify(NodeEvent{NodeConnected, info})
+	}
+}
+
+// RemoveNode removes a node. It returns false if the node was unknown.
+func (c *Connman) RemoveNode(id NodeID) bool {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+
+	c.mu.Lock()
+	n, ok := c.nodes[id]
+	delete(c.nodes, id)
+	logger := c.logger
+	c.mu.Unlock()
+
+	if ok {
+		logger.Debug("node disconnected", "node", id)
+		c.notify(NodeEvent{NodeDisconnected, n.info})
+	}
+	return ok
 }
 
+// NodesIDs returns the ids of all nodes
 func (c *Connman) NodesIDs() []NodeID {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	nodeIDs := make([]NodeID, 0, len(c.nodes))
 	for nodeID := range c.nodes {
 		nodeIDs = append(nodeIDs, nodeID)
 	}
 	return nodeIDs
 }
+
+// GetNodeInfo returns the metadata for the node, if it is known
+func (c *Connman) GetNodeInfo(id NodeID) (NodeInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n, ok := c.nodes[id]
+	if !ok {
+		return NodeInfo{}, false
+	}
+	return n.info, true
+}
+
+// NodeWeight returns the weight of the node, or zero if it is unknown
+func (c *Connman) NodeWeight(id NodeID) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if n, ok := c.nodes[id]; ok {
+		return n.info.Weight
+	}
+	return 0
+}
+
+// MarkSeen records that a valid message was received from the node at the
+// given time
+func (c *Connman) MarkSeen(id NodeID, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, ok := c.nodes[id]; ok {
+		n.info.LastSeen = at
+	}
+}
+
+// Subscribe registers a function to be called whenever a node is added or
+// removed. Events are delivered in order, after the change has been made, on
+// the goroutine that made it, so fn must not add or remove nodes, subscribe,
+// or unsubscribe. The returned function cancels the subscription.
+func (c *Connman) Subscribe(fn func(NodeEvent)) (unsubscribe func()) {
+	c.eventMu.Lock()
+	defer c.eventMu.Unlock()
+
+	id := c.nextSubscriberID
+	c.nextSubscriberID++
+	c.subscribers[id] = fn
+
+	return func() {
+		c.eventMu.Lock()
+		defer c.eventMu.Unlock()
+
+		delete(c.subscribers, id)
+	}
+}
+
+// notify sends the event to all subscribers. The caller must hold eventMu.
+func (c *Connman) notify(e NodeEvent) {
+	for _, fn := range c.subscribers {
+		fn(e)
+	}
+}
@@ -0,0 +1,134 @@
+package avalanche
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnman(t *testing.T) {
+	var (
+		connman = NewConnman()
+		events  = []NodeEvent{}
+		info    = NodeInfo{ID: NodeID(1), Address: "127.0.0.1:8333", Weight: 5, Inbound: true}
+	)
+	unsubscribe := connman.Subscribe(func(e NodeEvent) { events = append(events, e) })
+
+	// Adding nodes emits connect events once per node
+	connman.AddNode(NodeID(0))
+	connman.AddNodeWithInfo(info)
+	connman.AddNodeWithInfo(info)
+	assertTrue(t, len(events) == 2)
+	assertTrue(t, events[0].Type == NodeConnected && events[0].Node.ID == NodeID(0))
+	assertTrue(t, events[1].Type == NodeConnected && reflect.DeepEqual(events[1].Node, info))
+
+	ids := connman.NodesIDs()
+	sort.Sort(nodesInRequestOrder(ids))
+	assertTrue(t, len(ids) == 2 && ids[0] == NodeID(0) && ids[1] == NodeID(1))
+
+	// Metadata is kept per node
+	got, ok := connman.GetNodeInfo(NodeID(1))
+	assertTrue(t, ok && reflect.DeepEqual(got, info))
+	assertTrue(t, connman.NodeWeight(NodeID(0)) == DefaultNodeWeight)
+	assertTrue(t, connman.NodeWeight(NodeID(1)) == 5)
+	assertTrue(t, connman.NodeWeight(NodeID(2)) == 0)
+
+	now := time.Now()
+	connman.MarkSeen(NodeID(1), now)
+	got, _ = connman.GetNodeInfo(NodeID(1))
+	assertTrue(t, got.LastSeen.Equal(now))
+
+	// Removing nodes emits disconnect events
+	assertTrue(t, connman.RemoveNode(NodeID(1)))
+	assertFalse(t, connman.RemoveNode(NodeID(1)))
+	assertTrue(t, len(events) == 3)
+	assertTrue(t, events[2].Type == NodeDisconnected && events[2].Node.ID == NodeID(1))
+	_, ok = connman.GetNodeInfo(NodeID(1))
+	assertFalse(t, ok)
+
+	// No more events after unsubscribing
+	unsubscribe()
+	connman.RemoveNode(NodeID(0))
+	assertTrue(t, len(events) == 3)
+}
+
+func TestConnmanEventOrder(t *testing.T) {
+	var (
+		connman = NewConnman()
+		events  = []NodeEventType{}
+		wg      sync.WaitGroup
+	)
+	connman.Subscribe(func(e NodeEvent) { events = append(events, e.Type) })
+
+	// Events for a node alternate however its changes race
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				connman.AddNode(NodeID(0))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				connman.RemoveNode(NodeID(0))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, e := range events {
+		if e != NodeEventType(i%2) {
+			t.Fatal("Events out of order at", i, "got", events)
+		}
+	}
+}
+
+func TestProcessorCancelsQueriesToRemovedNodes(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		nodeID  = NodeID(0)
+		tx      = &testTx{hash: Hash{1}, accepted: true}
+	)
+	connman.AddNode(nodeID)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	round, invs := p.StartQuery(nodeID)
+	assertTrue(t, len(invs) == 1)
+
+	// Once the node leaves its query can no longer be answered
+	connman.RemoveNode(nodeID)
+	resp := NewResponse(round, 0, []Vote{NewVote(0, tx.Hash())})
+	assertResponseError(t, ErrUnknownQuery, p.RegisterVotes(nodeID, resp, &[]StatusUpdate{}))
+	assertTrue(t, p.getSuitableNodeToQuery() == NoNode)
+}
+
+func TestProcessorClose(t *testing.T) {
+	var (
+		connman = NewConnman()
+		nodeID  = NodeID(0)
+		tx      = &testTx{hash: Hash{1}, accepted: true}
+		now     = time.Unix(1000, 0)
+	)
+	connman.AddNode(nodeID)
+
+	p, err := NewProcessor(connman, DefaultConfig(), WithMetrics(NewMetrics()),
+		WithClock(func() time.Time { return now }))
+	assertNoError(t, err)
+	assertTrue(t, len(connman.subscribers) == 2)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	// Nodes are marked as seen with the Processor's clock
+	assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{tx.Hash(): 0}, &[]StatusUpdate{}))
+	info, _ := connman.GetNodeInfo(nodeID)
+	assertTrue(t, info.LastSeen.Equal(now))
+
+	// Closing releases the subscriptions to the shared Connman
+	p.Close()
+	p.Close()
+	assertTrue(t, len(connman.subscribers) == 0)
+}
@@ -0,0 +1,120 @@
+package avalanche
+
+import (
+	"crypto/ed25519"
+	"math/rand"
+	"time"
+)
+
+// Option configures optional behavior of a Processor
+type Option func(*Processor) error
+
+// WithTransport has the Processor send its polls over the given Transport and
+// register the responses that it delivers
+func WithTransport(t Transport) Option {
+	return func(p *Processor) error {
+		p.transport = t
+		return nil
+	}
+}
+
+// WithCooldown has the Processor choose the cooldown it advertises in its
+// responses with the given function. By default it advertises none.
+func WithCooldown(fn CooldownFunc) Option {
+	return func(p *Processor) error {
+		p.cooldownFunc = fn
+		return nil
+	}
+}
+
+// WithQueryTimeoutHandler has the Processor call the given function for each
+// query that expires without a response. The Invs it asked about are polled
+// from another node.
+func WithQueryTimeoutHandler(fn QueryTimeoutFunc) Option {
+	return func(p *Processor) error {
+		p.onTimeout = fn
+		return nil
+	}
+}
+
+// WithIdentityKey has the Processor sign the responses it builds with Respond
+// using the given key
+func WithIdentityKey(key ed25519.PrivateKey) Option {
+	return func(p *Processor) error {
+		p.identityKey = key
+		return nil
+	}
+}
+
+// WithRequiredSignatures has the Processor reject responses from nodes that
+// have no public key in the Connman. Responses from nodes with a key are
+// always verified.
+func WithRequiredSignatures() Option {
+	return func(p *Processor) error {
+		p.requireSignatures = true
+		return nil
+	}
+}
+
+// WithTypePriority has the Processor poll Targets of types with a higher
+// priority before those with a lower one, regardless of their Score; e.g.
+// {"block": 1} polls blocks before transactions. Types that are not listed
+// have a priority of zero.
+func WithTypePriority(priority map[string]int) Option {
+	return func(p *Processor) error {
+		p.typePriority = make(map[string]int, len(priority))
+		for t, v := range priority {
+			p.typePriority[t] = v
+		}
+		return nil
+	}
+}
+
+// WithMetrics has the Processor record metrics about itself and its Connman
+// in m, which can then serve them over HTTP
+func WithMetrics(m *Metrics) Option {
+	return func(p *Processor) error {
+		p.metrics = m
+		return nil
+	}
+}
+
+// WithLogger has the Processor record poll dispatch, rejected responses,
+// status changes and finalizations with the given Logger. By default nothing
+// is logged.
+func WithLogger(l Logger) Option {
+	return func(p *Processor) error {
+		p.logger = l
+		return nil
+	}
+}
+
+// WithAuditTrail has the Processor record every vote it registers for each
+// Target, and every change to the Target's confidence and status, so that
+// they can be retrieved with GetAuditTrail. If limit is positive only the most
+// recent limit entries are kept for each Target.
+func WithAuditTrail(limit int) Option {
+	return func(p *Processor) error {
+		p.audit = map[Hash][]AuditEntry{}
+		p.auditLimit = limit
+		return nil
+	}
+}
+
+// WithClock has the Processor read the current time from the given function
+// rather than the system clock; e.g. to run in virtual time
+func WithClock(now func() time.Time) Option {
+	return func(p *Processor) error {
+		p.now = now
+		return nil
+	}
+}
+
+// WithRand has the Processor use the given source of randomness when choosing
+// nodes to poll. It is useful for deterministic tests and simulations.
+func WithRand(r *rand.Rand) Option {
+	return func(p *Processor) error {
+		p.rand = r
+		return nil
+	}
+}
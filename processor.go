@@ -1,48 +1,185 @@
 package avalanche
 
 import (
-	"fmt"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"math"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
 )
 
+var (
+	// ErrAlreadyRunning is returned when starting a Processor that is running
+	ErrAlreadyRunning = errors.New("avalanche: processor is already running")
+
+	// ErrNotRunning is returned when stopping a Processor that is not running
+	ErrNotRunning = errors.New("avalanche: processor is not running")
+)
+
 // Processor drives the Avalanche process by sending queries and handling
-// responses.
+// responses. All exported methods are safe for concurrent use.
 type Processor struct {
-	connman *Connman
+	cfg           Config
+	connman       *Connman
+	unsubscribers []func()
+	closeOnce     sync.Once
+	transport     Transport
+	rand          *rand.Rand
+	now           func() time.Time
+	cooldownFunc  CooldownFunc
+	onTimeout     QueryTimeoutFunc
+	typePriority  map[string]int
+	metrics       *Metrics
+	logger        Logger
+
+	identityKey       ed25519.PrivateKey
+	requireSignatures bool
+
+	// mu guards all of the consensus state below
+	mu           sync.RWMutex
+	round        int64
+	targets      map[Hash]Target
+	voteRecords  map[Hash]*VoteRecord
+	restored     map[Hash]*VoteRecord
+	finalized    map[Hash]bool
+	conflictSets map[string]*conflictSet
+	dag          *dag
+	nodeIDs      map[NodeID]struct{}
+	queries      map[requestKey]RequestRecord
+	answered     map[requestKey]int64
+	expired      map[requestKey]int64
+	cooldowns    map[NodeID]time.Time
+	timeouts     map[NodeID]int
+	recentPolls  []time.Time
+	timings      map[Hash]*targetTiming
+
+	// audit is nil unless the audit trail is enabled
+	audit      map[Hash][]AuditEntry
+	auditLimit int
+
+	totalTimeouts uint64
+
+	// pending holds the StatusUpdates waiting to be delivered in the order the
+	// changes were made. It is appended to while holding mu and drained while
+	// holding notifyMu, which serializes delivery to subscribers.
+	pendingMu        sync.Mutex
+	pending          []pendingUpdate
+	notifyMu         sync.Mutex
+	subscribersMu    sync.Mutex
+	subscribers      map[int]subscription
+	nextSubscriberID int
+
+	// lifecycleMu serializes Start and Stop, and runMu guards the state of
+	// the event loop
+	lifecycleMu sync.Mutex
+	runMu       sync.Mutex
+	isRunning   bool
+	quitCh      chan (struct{})
+	doneCh      chan (struct{})
+}
 
-	round       int64
-	targets     map[Hash]Target
-	voteRecords map[Hash]*VoteRecord
-	nodeIDs     map[NodeID]struct{}
-	queries     map[string]RequestRecord
+// NewProcessor creates a new *Processor using the given Config. Zero-valued
+// fields in the Config are replaced with their defaults.
+func NewProcessor(connman *Connman, cfg Config, opts ...Option) (*Processor, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &Processor{
+		voteRecords:  map[Hash]*VoteRecord{},
+		restored:     map[Hash]*VoteRecord{},
+		finalized:    map[Hash]bool{},
+		conflictSets: map[string]*conflictSet{},
+		dag:          newDAG(),
+		targets:      map[Hash]Target{},
+		queries:      map[requestKey]RequestRecord{},
+		answered:     map[requestKey]int64{},
+		expired:      map[requestKey]int64{},
+		nodeIDs:      map[NodeID]struct{}{},
+		cooldowns:    map[NodeID]time.Time{},
+		timeouts:     map[NodeID]int{},
+		timings:      map[Hash]*targetTiming{},
+		subscribers:  map[int]subscription{},
+
+		cfg:          cfg,
+		connman:      connman,
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:          func() time.Time { return clock.Now() },
+		cooldownFunc: NoCooldown,
+		logger:       nopLogger{},
+	}
 
-	runMu     sync.Mutex
-	isRunning bool
-	quitCh    chan (struct{})
-	doneCh    chan (struct{})
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+
+	p.unsubscribers = append(p.unsubscribers, connman.Subscribe(p.handleNodeEvent))
+
+	if p.metrics != nil {
+		p.metrics.trackProcessor(p)
+		p.unsubscribers = append(p.unsubscribers, p.metrics.trackConnman(connman))
+	}
+
+	return p, nil
 }
 
-// NewProcessor creates a new *Processor
-func NewProcessor(connman *Connman) *Processor {
-	return &Processor{
-		voteRecords: map[Hash]*VoteRecord{},
-		targets:     map[Hash]Target{},
-		queries:     map[string]RequestRecord{},
-		nodeIDs:     map[NodeID]struct{}{},
+// Close cancels the Processor's subscriptions to its Connman so that the
+// Processor is no longer reachable from it; e.g. when many Processors share a
+// Connman over time. The event loop should be stopped first. Afterward the
+// Processor no longer notices nodes being removed.
+func (p *Processor) Close() {
+	p.closeOnce.Do(func() {
+		for _, unsubscribe := range p.unsubscribers {
+			unsubscribe()
+		}
+	})
+}
 
-		connman: connman,
+// handleNodeEvent cancels the queries to nodes that have been removed since
+// they will never be answered
+func (p *Processor) handleNodeEvent(e NodeEvent) {
+	if e.Type != NodeDisconnected {
+		return
 	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key := range p.queries {
+		if key.nodeID == e.Node.ID {
+			delete(p.queries, key)
+		}
+	}
+	delete(p.nodeIDs, e.Node.ID)
+	delete(p.cooldowns, e.Node.ID)
+	delete(p.timeouts, e.Node.ID)
+}
+
+// Config returns the Config the *Processor is using
+func (p *Processor) Config() Config {
+	return p.cfg
 }
 
-// GetRound returns the current round for the *Processor
+// GetRound returns the round the *Processor will use for its next query.
+// Every query is made in its own round.
 func (p *Processor) GetRound() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	return p.round
 }
 
 // AddTargetToReconcile begins the voting process for a given target
 func (p *Processor) AddTargetToReconcile(t Target) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if !p.isWorthyPolling(t) {
 		return false
 	}
@@ -52,57 +189,130 @@ func (p *Processor) AddTargetToReconcile(t Target) bool {
 		return false
 	}
 
+	// Resume from a restored snapshot if there is a record for the Target
+	vr, restored := p.restored[t.Hash()]
+	if restored {
+		delete(p.restored, t.Hash())
+	} else {
+		vr = NewVoteRecord(t.IsAccepted(), p.cfg)
+	}
+	accepted := vr.isAccepted()
+
+	// Only one member of a conflict set may be accepted
+	if ct, ok := t.(ConflictingTarget); ok {
+		accepted = p.addToConflictSets(ct, accepted)
+	}
+
+	if dt, ok := t.(DAGTarget); ok {
+		p.dag.add(t.Hash(), dt.Parents())
+	}
+
+	if !accepted && vr.isAccepted() {
+		vr.reject()
+	}
+
 	p.targets[t.Hash()] = t
-	p.voteRecords[t.Hash()] = NewVoteRecord(t.IsAccepted())
+	p.voteRecords[t.Hash()] = vr
+	delete(p.finalized, t.Hash())
+	p.startTiming(t.Hash(), vr.isAccepted())
 	return true
 }
 
-// RegisterVotes processes responses to queries
-func (p *Processor) RegisterVotes(id NodeID, resp Response, updates *[]StatusUpdate) bool {
-	// Disabled while hacking on simulations
-	if false {
-		key := queryKey(resp.GetRound(), id)
+// RegisterVotes processes responses to queries. If the Response does not
+// match an outstanding query to the node, or is not signed by the node's key,
+// a *ResponseError is returned and no votes are registered. The resulting
+// StatusUpdates are appended to updates and delivered to subscribers.
+func (p *Processor) RegisterVotes(id NodeID, resp Response, updates *[]StatusUpdate) error {
+	produced, err := p.registerAndQueueVotes(id, resp)
 
-		r, ok := p.queries[key]
-		if !ok {
-			return false
-		}
+	// Delivery happens without holding the state lock so that subscribers
+	// can read it
+	p.deliver()
 
-		// Always delete the key if it's present
-		delete(p.queries, key)
+	if err != nil {
+		p.logger.Warn("rejected response", "node", id, "round", resp.GetRound(), "err", err)
+		return err
+	}
 
-		if r.IsExpired() {
-			return false
-		}
+	p.logUpdates(id, resp.GetRound(), produced)
+	*updates = append(*updates, produced...)
+	return nil
+}
 
-		invs := r.GetInvs()
-		votes := resp.GetVotes()
+// registerAndQueueVotes registers the votes and queues the resulting updates
+// for delivery. The updates are queued before the lock is released so that
+// concurrent calls deliver them in the order they were made.
+func (p *Processor) registerAndQueueVotes(id NodeID, resp Response) ([]StatusUpdate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	produced := []StatusUpdate{}
+	err := p.registerVotes(id, resp, &produced)
+	p.recordFinalized(produced)
+	p.auditUpdates(id, resp.GetRound(), produced)
+	p.metrics.responseReceived(resp, err)
+	p.observeUpdates(produced)
+	p.enqueue(produced, p.targetTypes(produced))
+
+	return produced, err
+}
 
-		if len(votes) != len(invs) {
-			return false
-		}
+// registerVotes is RegisterVotes for callers that already hold the lock. It
+// does not deliver the updates to subscribers.
+func (p *Processor) registerVotes(id NodeID, resp Response, updates *[]StatusUpdate) error {
+	// Forged responses must not consume the query they claim to answer
+	if err := p.verifyResponse(id, resp); err != nil {
+		return &ResponseError{NodeID: id, Round: resp.GetRound(), Err: err}
+	}
 
-		for i, v := range votes {
-			if invs[i].TargetHash != v.GetHash() {
-				return false
-			}
-		}
+	if err := p.validateResponse(id, resp); err != nil {
+		return &ResponseError{NodeID: id, Round: resp.GetRound(), Err: err}
 	}
 
-	votes := resp.GetVotes()
+	// Votes for DAG vertices count toward their ancestors as well. The implied
+	// votes come first.
+	votes := p.withTransitiveVotes(resp.GetVotes())
+	implied := len(votes) - len(resp.GetVotes())
+	now := p.now()
 
-	for _, v := range votes {
+	for i, v := range votes {
 		vr, ok := p.voteRecords[v.GetHash()]
 		if !ok {
 			// We are not voting on this anymore
 			continue
 		}
 
-		if !p.isWorthyPolling(p.targets[v.GetHash()]) {
+		t := p.targets[v.GetHash()]
+		if !p.isWorthyPolling(t) {
 			continue
 		}
 
-		if !vr.regsiterVote(v.GetError()) {
+		p.auditVote(id, resp.GetRound(), v, i < implied, now)
+		before := vr.confidence
+
+		changed := vr.regsiterVote(v.GetError())
+
+		ct, isConflicting := t.(ConflictingTarget)
+		if isConflicting {
+			changed = p.applyConflictVote(ct, vr, changed, updates)
+		}
+
+		vtx, isVertex := p.dag.vertices[v.GetHash()]
+		if isVertex {
+			if vr.hasYesQuorum() {
+				vtx.chit = true
+			}
+
+			// A vertex can't be finalized as accepted before its parents are
+			if changed && vr.hasFinalized() && vr.isAccepted() && !p.dag.parentsDecided(v.GetHash()) {
+				vr.holdFinalization()
+				changed = false
+			}
+		}
+
+		p.auditConfidence(id, resp.GetRound(), v.GetHash(), before, vr, now)
+
+		if !changed {
 			// This vote did not provide any extra information
 			continue
 		}
@@ -113,16 +323,130 @@ func (p *Processor) RegisterVotes(id NodeID, resp Response, updates *[]StatusUpd
 		// When we finalize we want to remove our vote record
 		if vr.hasFinalized() {
 			delete(p.voteRecords, v.GetHash())
+
+			if isConflicting {
+				p.finalizeConflicts(ct, vr.isAccepted(), updates)
+			}
+
+			if isVertex {
+				if !vr.isAccepted() {
+					p.invalidateProgeny(v.GetHash(), updates)
+				}
+				p.dag.remove(v.GetHash())
+			}
 		}
 	}
 
 	p.nodeIDs[id] = struct{}{}
+	p.recordCooldown(id, resp)
+	delete(p.timeouts, id)
+	p.connman.MarkSeen(id, p.now())
 
-	return true
+	return nil
+}
+
+// recordFinalized remembers the outcome of the Targets that were finalized
+func (p *Processor) recordFinalized(updates []StatusUpdate) {
+	for _, u := range updates {
+		switch u.Status {
+		case StatusFinalized:
+			p.finalized[u.Hash] = true
+		case StatusInvalid:
+			p.finalized[u.Hash] = false
+		}
+	}
+}
+
+// GetFinalized returns whether or not consensus on the Target with the given
+// hash was finalized, and if so whether it was accepted. Adding the Target to
+// reconcile again clears its outcome.
+func (p *Processor) GetFinalized(h Hash) (accepted bool, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	accepted, ok = p.finalized[h]
+	return accepted, ok
+}
+
+// ForgetFinalized discards the outcome of the Target with the given hash,
+// along with its audit trail. The outcomes are otherwise kept for as long as
+// the Processor is, so callers should forget Targets once they no longer need
+// to look them up.
+func (p *Processor) ForgetFinalized(h Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.finalized, h)
+	delete(p.audit, h)
+}
+
+// verifyResponse checks that the Response is signed by the node's key, if it
+// has one
+func (p *Processor) verifyResponse(id NodeID, resp Response) error {
+	info, _ := p.connman.GetNodeInfo(id)
+	if len(info.PublicKey) == 0 {
+		if p.requireSignatures {
+			return ErrUnknownNodeKey
+		}
+		return nil
+	}
+
+	if !resp.Verify(info.PublicKey) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// validateResponse checks that the Response answers an outstanding query to
+// the node. The query is consumed whether or not the Response is valid.
+func (p *Processor) validateResponse(id NodeID, resp Response) error {
+	key := queryKey(resp.GetRound(), id)
+
+	r, ok := p.queries[key]
+	if !ok {
+		if _, ok := p.answered[key]; ok {
+			return ErrDuplicateResponse
+		}
+		if _, ok := p.expired[key]; ok {
+			return ErrExpiredQuery
+		}
+		return ErrUnknownQuery
+	}
+
+	// Always delete the key if it's present
+	delete(p.queries, key)
+	p.answered[key] = r.GetTimestamp()
+
+	if p.isExpired(r) {
+		return ErrExpiredQuery
+	}
+
+	invs := r.GetInvs()
+	votes := resp.GetVotes()
+
+	if len(votes) != len(invs) {
+		return ErrVoteCountMismatch
+	}
+
+	for i, v := range votes {
+		if invs[i].TargetHash != v.GetHash() {
+			return ErrVoteHashMismatch
+		}
+	}
+
+	return nil
 }
 
 // IsAccepted returns whether or not the Traget has been accepted by consensus
 func (p *Processor) IsAccepted(t Target) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.isAccepted(t)
+}
+
+// isAccepted is IsAccepted for callers that already hold the lock
+func (p *Processor) isAccepted(t Target) bool {
 	if vr, ok := p.voteRecords[t.Hash()]; ok {
 		return vr.isAccepted()
 	}
@@ -131,6 +455,9 @@ func (p *Processor) IsAccepted(t Target) bool {
 
 // GetConfidence returns the confidence we have in the Target's acceptance
 func (p *Processor) GetConfidence(t Target) uint16 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	vr, ok := p.voteRecords[t.Hash()]
 	if !ok {
 		panic("VoteRecord not found")
@@ -140,8 +467,18 @@ func (p *Processor) GetConfidence(t Target) uint16 {
 }
 
 // GetInvsForNextPoll returns Invs for outstanding items that need to be
-// resolved by further queries
+// resolved by further queries, most important first. At most MaxElementPoll
+// Invs are returned.
 func (p *Processor) GetInvsForNextPoll() []Inv {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.getInvsForNextPoll()
+}
+
+// getInvsForNextPoll is GetInvsForNextPoll for callers that already hold the
+// lock
+func (p *Processor) getInvsForNextPoll() []Inv {
 	invs := make([]Inv, 0, len(p.voteRecords))
 	for idx, r := range p.voteRecords {
 		if r.hasFinalized() {
@@ -156,29 +493,140 @@ func (p *Processor) GetInvsForNextPoll() []Inv {
 			continue
 		}
 
+		// Vertices with undecided children are voted on through them. Polling
+		// them directly as well would let a neutral answer about the vertex
+		// override the yes vote implied by a yes vote for its child.
+		if p.dag.hasChildren(idx) {
+			continue
+		}
+
 		// We don't have a decision, we need more votes.
 		invs = append(invs, Inv{t.Type(), idx})
 	}
 
-	// sortBlockInvsByWork(invs)
+	// Put the most important invs first so that truncation drops the least
+	sort.Slice(invs, func(i, j int) bool {
+		return p.pollsBefore(invs[i], invs[j])
+	})
 
-	if len(invs) >= AvalancheMaxElementPoll {
-		invs = invs[:AvalancheMaxElementPoll]
+	if len(invs) >= p.cfg.MaxElementPoll {
+		invs = invs[:p.cfg.MaxElementPoll]
 	}
 
 	return invs
 }
 
-// getSuitableNodeToQuery returns the best node to send the next query to
+// pollsBefore returns whether or not the first Inv is more important to poll
+// than the second. Invs are ordered by the priority of their type, then by the
+// Score of their Target, then by hash so that the order is deterministic.
+func (p *Processor) pollsBefore(a, b Inv) bool {
+	if pa, pb := p.typePriority[a.TargetType], p.typePriority[b.TargetType]; pa != pb {
+		return pa > pb
+	}
+
+	if sa, sb := p.targets[a.TargetHash].Score(), p.targets[b.TargetHash].Score(); sa != sb {
+		return sa > sb
+	}
+
+	return a.TargetHash.Compare(b.TargetHash) < 0
+}
+
+// getSuitableNodeToQuery returns a random node to send the next query to,
+// chosen with probability proportional to its weight. Nodes that have not yet
+// answered their last query or asked us to cool down are skipped, and nodes
+// that let queries time out are picked less often.
 func (p *Processor) getSuitableNodeToQuery() NodeID {
+	return p.getSuitableNodeToQueryExcept(NoNode)
+}
+
+// getSuitableNodeToQueryExcept is getSuitableNodeToQuery without considering
+// the given node
+func (p *Processor) getSuitableNodeToQueryExcept(exclude NodeID) NodeID {
 	nodeIDs := p.connman.NodesIDs()
 
+	// Sort so that selection only depends on the random source
 	sort.Sort(nodesInRequestOrder(nodeIDs))
 
-	if len(nodeIDs) == 0 {
+	busy := p.nodesWithPendingQueries()
+	now := p.now()
+
+	var (
+		candidates  = make([]NodeID, 0, len(nodeIDs))
+		weights     = make([]uint64, 0, len(nodeIDs))
+		totalWeight uint64
+	)
+	for _, id := range nodeIDs {
+		if id == exclude {
+			continue
+		}
+
+		if _, ok := busy[id]; ok {
+			continue
+		}
+
+		if p.isCoolingDown(id, now) {
+			continue
+		}
+
+		weight := p.connman.NodeWeight(id)
+		if weight == 0 {
+			continue
+		}
+
+		weight = p.penalizedWeight(id, weight)
+
+		candidates = append(candidates, id)
+		weights = append(weights, weight)
+		totalWeight += weight
+	}
+
+	if len(candidates) == 0 {
 		return NoNode
 	}
-	return nodeIDs[0]
+
+	slot := randUint64n(p.rand, totalWeight)
+	for i, weight := range weights {
+		if slot < weight {
+			return candidates[i]
+		}
+		slot -= weight
+	}
+
+	return candidates[len(candidates)-1]
+}
+
+// nodesWithPendingQueries returns the nodes with queries that are still
+// waiting on an answer
+func (p *Processor) nodesWithPendingQueries() map[NodeID]struct{} {
+	busy := map[NodeID]struct{}{}
+	for key, r := range p.queries {
+		if !p.isExpired(r) {
+			busy[key.nodeID] = struct{}{}
+		}
+	}
+	return busy
+}
+
+// randUint64n returns a uniformly random number in [0, n)
+func randUint64n(r *rand.Rand, n uint64) uint64 {
+	if n <= math.MaxInt64 {
+		return uint64(r.Int63n(int64(n)))
+	}
+
+	// Reject values that would bias the result
+	max := math.MaxUint64 - math.MaxUint64%n
+	for {
+		v := r.Uint64()
+		if v < max {
+			return v % n
+		}
+	}
+}
+
+// isExpired returns whether or not the query has gone unanswered for longer
+// than the request timeout
+func (p *Processor) isExpired(r RequestRecord) bool {
+	return r.isExpiredAt(p.now(), p.cfg.RequestTimeout)
 }
 
 // isWorthyPolling determines whether or it's even worth polling about a Target
@@ -186,63 +634,277 @@ func (p *Processor) isWorthyPolling(t Target) bool {
 	return t.IsValid()
 }
 
-// start begins the poll/response cycle
-func (p *Processor) start() bool {
+// Start begins the poll/response cycle. The event loop runs until Stop is
+// called or the context is done.
+func (p *Processor) Start(ctx context.Context) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
 	p.runMu.Lock()
 	defer p.runMu.Unlock()
 
-	if p.isRunning {
-		return false
+	if p.isRunningLocked() {
+		return ErrAlreadyRunning
 	}
 
 	p.isRunning = true
 	p.quitCh = make(chan (struct{}))
 	p.doneCh = make(chan (struct{}))
 
-	go func() {
-		t := time.NewTicker(AvalancheTimeStep)
-		for {
-			select {
-			case <-p.quitCh:
-				close(p.doneCh)
-				return
-			case <-t.C:
-				p.eventLoop()
-			}
-		}
-	}()
+	go p.run(ctx, p.quitCh, p.doneCh)
 
-	return true
+	return nil
+}
+
+// Stop ends the poll/response cycle and waits for the event loop to exit.
+// Outstanding queries may still be answered until the context is done, after
+// which any that remain are cancelled. Pass a context that is already done to
+// cancel them immediately. Unanswered queries are only given up on once they
+// expire, so with a context that is never done Stop can block for up to the
+// RequestTimeout. IsRunning reports false while the queries drain, and Start
+// waits until they have.
+func (p *Processor) Stop(ctx context.Context) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
+	p.runMu.Lock()
+	if !p.isRunning {
+		p.runMu.Unlock()
+		return ErrNotRunning
+	}
+
+	close(p.quitCh)
+	doneCh := p.doneCh
+	p.isRunning = false
+	p.runMu.Unlock()
+
+	<-doneCh
+	p.drainQueries(ctx)
+	return nil
 }
 
-// stop ends the poll/response cycle
-func (p *Processor) stop() bool {
+// IsRunning returns whether or not the event loop is running
+func (p *Processor) IsRunning() bool {
 	p.runMu.Lock()
 	defer p.runMu.Unlock()
 
+	return p.isRunningLocked()
+}
+
+// isRunningLocked is IsRunning for callers that already hold runMu. The event
+// loop may have exited on its own if its context is done.
+func (p *Processor) isRunningLocked() bool {
 	if !p.isRunning {
 		return false
 	}
 
-	close(p.quitCh)
-	<-p.doneCh
+	select {
+	case <-p.doneCh:
+		return false
+	default:
+		return true
+	}
+}
 
-	p.isRunning = false
-	return true
+// run is the event loop goroutine
+func (p *Processor) run(ctx context.Context, quitCh, doneCh chan (struct{})) {
+	defer close(doneCh)
+
+	t := time.NewTicker(p.cfg.TimeStep)
+	defer t.Stop()
+
+	// Without a transport responses are registered by the caller
+	var responses <-chan IncomingResponse
+	if p.transport != nil {
+		responses = p.transport.Responses()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-quitCh:
+			return
+		case <-t.C:
+			p.tick()
+		case r := <-responses:
+			p.handleResponse(r)
+		}
+	}
 }
 
-// eventLoop performs a tick of processing
-func (p *Processor) eventLoop() {
-	invs := p.GetInvsForNextPoll()
-	if len(invs) == 0 {
+// handleResponse registers a Response delivered by the transport. The
+// resulting updates reach callers through subscriptions. Like tick, a panic is
+// recovered so that it only drops that Response and not the loop itself.
+func (p *Processor) handleResponse(r IncomingResponse) {
+	defer func() {
+		if v := recover(); v != nil {
+			p.logger.Error("recovered from panic while registering a response", "panic", v, "node", r.NodeID)
+		}
+	}()
+
+	// Invalid responses are dropped
+	updates := []StatusUpdate{}
+	_ = p.RegisterVotes(r.NodeID, r.Response, &updates)
+}
+
+// tick runs Tick for the event loop. A panic inside the tick is recovered so
+// that it only abandons that iteration and not the loop itself.
+func (p *Processor) tick() {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("recovered from panic in tick", "panic", r)
+		}
+	}()
+
+	p.Tick()
+}
+
+// Tick performs one iteration of the event loop: expired queries are swept
+// and the next node is polled. It is called by the event loop started with
+// Start, and can be called directly to drive a Processor without one; e.g. in
+// a simulation. Unlike in the event loop, a panic is not recovered so that it
+// reaches the caller.
+func (p *Processor) Tick() {
+	// Poll other nodes about what the expired queries asked for
+	for _, q := range p.sweepQueries() {
+		p.logger.Debug("query timed out", "node", q.key.nodeID, "round", q.key.round, "invs", len(q.invs))
+		if p.onTimeout != nil {
+			p.onTimeout(q.key.nodeID, q.key.round, q.invs)
+		}
+		p.sendPoll(p.requeue(q))
+	}
+
+	p.sendPoll(p.eventLoop())
+}
+
+// sendPoll sends a query that was started over the transport, if there is one
+func (p *Processor) sendPoll(id NodeID, round int64, invs []Inv) {
+	if len(invs) == 0 || p.transport == nil {
 		return
 	}
 
+	// The poll is sent without holding the lock since the transport may block
+	if err := p.transport.SendPoll(id, round, invs); err != nil {
+		p.logger.Warn("failed to send poll", "node", id, "round", round, "err", err)
+		p.metrics.pollFailed()
+		p.cancelQuery(round, id)
+	}
+}
+
+// drainQueries waits for outstanding queries to be answered until the context
+// is done or they have all expired, and then cancels any that remain.
+// Responses delivered by the transport are registered in the meantime.
+func (p *Processor) drainQueries(ctx context.Context) {
+	t := time.NewTicker(p.cfg.TimeStep)
+	defer t.Stop()
+
+	var responses <-chan IncomingResponse
+	if p.transport != nil {
+		responses = p.transport.Responses()
+	}
+
+	for p.hasPendingQueries() {
+		select {
+		case <-ctx.Done():
+			p.cancelQueries()
+			return
+		case r := <-responses:
+			p.handleResponse(r)
+		case <-t.C:
+		}
+	}
+
+	p.cancelQueries()
+}
+
+// cancelQueries discards all outstanding queries
+func (p *Processor) cancelQueries() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queries = map[requestKey]RequestRecord{}
+}
+
+// hasPendingQueries returns whether or not any outstanding query can still be
+// answered
+func (p *Processor) hasPendingQueries() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, r := range p.queries {
+		if !p.isExpired(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartQuery records an outstanding query to the given node for the Invs that
+// currently need votes, and returns the round and Invs to send to it. Each
+// query advances the round. No Invs are returned if there is nothing to poll.
+func (p *Processor) StartQuery(id NodeID) (int64, []Inv) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.startQuery(id)
+}
+
+// startQuery is StartQuery for callers that already hold the lock
+func (p *Processor) startQuery(id NodeID) (int64, []Inv) {
+	invs := p.getInvsForNextPoll()
+	if len(invs) == 0 {
+		return p.round, nil
+	}
+
+	return p.recordQuery(id, invs)
+}
+
+// recordQuery records an outstanding query to the node for the given Invs in
+// a new round, and returns that round
+func (p *Processor) recordQuery(id NodeID, invs []Inv) (int64, []Inv) {
+	now := p.now()
+	round := p.round
+	p.round++
+
+	p.queries[queryKey(round, id)] = NewRequestRecord(now.UnixNano(), invs)
+	p.metrics.pollSent()
+	p.logger.Debug("sending poll", "node", id, "round", round, "invs", len(invs))
+	p.forgetOldQueries(now)
+
+	return round, invs
+}
+
+// eventLoop performs a tick of processing. It returns the query that was
+// started, if any, so that it can be sent.
+func (p *Processor) eventLoop() (NodeID, int64, []Inv) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	nodeID := p.getSuitableNodeToQuery()
-	p.queries[queryKey(p.round, nodeID)] = NewRequestRecord(clock.Now().Unix(), invs)
+	if nodeID == NoNode {
+		return nodeID, p.round, nil
+	}
+
+	round, invs := p.startQuery(nodeID)
+	return nodeID, round, invs
+}
+
+// cancelQuery discards an outstanding query that could not be sent
+func (p *Processor) cancelQuery(round int64, id NodeID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.queries, queryKey(round, id))
+}
+
+// requestKey identifies a query by the round and the node it was sent to
+type requestKey struct {
+	round  int64
+	nodeID NodeID
 }
 
-// queryKey returns a string to use for map keys that reprents the given inputs
-func queryKey(round int64, nodeID NodeID) string {
-	return fmt.Sprintf("%d|%d", round, nodeID)
+// queryKey returns a key to use for maps that represents the given inputs
+func queryKey(round int64, nodeID NodeID) requestKey {
+	return requestKey{round, nodeID}
 }
@@ -1,17 +1,112 @@
 package avalanche
 
-import "time"
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+)
 
-// Response is a list of votes that respond to a Poll
+var (
+	// ErrUnknownQuery is returned when a Response does not match any
+	// outstanding query to the node
+	ErrUnknownQuery = errors.New("avalanche: response does not match an outstanding query")
+
+	// ErrExpiredQuery is returned when a Response arrives after the query timed
+	// out
+	ErrExpiredQuery = errors.New("avalanche: response to expired query")
+
+	// ErrVoteCountMismatch is returned when a Response has a different number
+	// of votes than the query had invs
+	ErrVoteCountMismatch = errors.New("avalanche: response vote count does not match query")
+
+	// ErrVoteHashMismatch is returned when the votes in a Response are not for
+	// the invs of the query in the same order
+	ErrVoteHashMismatch = errors.New("avalanche: response vote hashes do not match query")
+
+	// ErrDuplicateResponse is returned when a query has already been answered
+	ErrDuplicateResponse = errors.New("avalanche: duplicate response to query")
+
+	// ErrInvalidSignature is returned when a Response is not signed by the key
+	// of the node it came from
+	ErrInvalidSignature = errors.New("avalanche: invalid response signature")
+
+	// ErrUnknownNodeKey is returned when signatures are required and the node
+	// a Response came from has no key
+	ErrUnknownNodeKey = errors.New("avalanche: no key for responding node")
+)
+
+// ResponseError is returned when a Response from a node is rejected. Callers
+// can use it to penalize misbehaving nodes.
+type ResponseError struct {
+	NodeID NodeID
+	Round  int64
+	Err    error
+}
+
+// Error implements the error interface
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%v (node %d, round %d)", e.Err, e.NodeID, e.Round)
+}
+
+// Unwrap returns the underlying reason the Response was rejected
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Response is a list of votes that respond to a Poll. The cooldown is the
+// number of milliseconds the responder asks us to wait before polling it again.
 type Response struct {
-	round    int64
-	cooldown uint32
-	votes    []Vote
+	round     int64
+	cooldown  uint32
+	votes     []Vote
+	signature []byte
 }
 
-// NewResponse creates a new Response object with the given votes
+// NewResponse creates a new unsigned Response object with the given votes
 func NewResponse(round int64, cooldown uint32, votes []Vote) Response {
-	return Response{round, cooldown, votes}
+	return Response{round: round, cooldown: cooldown, votes: votes}
+}
+
+// Sign returns a copy of the Response signed with the responder's key. The
+// signature covers the round, cooldown, and votes as encoded on the wire.
+func (r Response) Sign(key ed25519.PrivateKey) (Response, error) {
+	msg, err := r.signedMessage()
+	if err != nil {
+		return r, err
+	}
+
+	r.signature = ed25519.Sign(key, msg)
+	return r, nil
+}
+
+// Verify returns whether or not the Response was signed with the private half
+// of the given key
+func (r Response) Verify(key ed25519.PublicKey) bool {
+	if len(key) != ed25519.PublicKeySize || len(r.signature) != ed25519.SignatureSize {
+		return false
+	}
+
+	msg, err := r.signedMessage()
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, msg, r.signature)
+}
+
+// GetSignature returns the signature of the Response, if it is signed
+func (r Response) GetSignature() []byte {
+	return r.signature
+}
+
+// signedMessage returns the bytes covered by the signature
+func (r Response) signedMessage() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := EncodeResponse(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // GetVotes returns the votes in the Response
@@ -24,18 +119,30 @@ func (r Response) GetRound() int64 {
 	return r.round
 }
 
+// GetCooldown returns the cooldown of the Response in milliseconds
+func (r Response) GetCooldown() uint32 {
+	return r.cooldown
+}
+
+// GetCooldownDuration returns the cooldown of the Response as a Duration
+func (r Response) GetCooldownDuration() time.Duration {
+	return time.Duration(r.cooldown) * time.Millisecond
+}
+
 // RequestRecord is a poll request for more votes
 type RequestRecord struct {
 	timestamp int64
 	invs      []Inv
 }
 
-// NewRequestRecord creates a new RequestRecord
+// NewRequestRecord creates a new RequestRecord. The timestamp is in
+// nanoseconds since the Unix epoch.
 func NewRequestRecord(timestamp int64, invs []Inv) RequestRecord {
 	return RequestRecord{timestamp, invs}
 }
 
-// GetTimestamp returns the timestamp that the request was created
+// GetTimestamp returns the time that the request was created in nanoseconds
+// since the Unix epoch
 func (r RequestRecord) GetTimestamp() int64 {
 	return r.timestamp
 }
@@ -45,7 +152,12 @@ func (r RequestRecord) GetInvs() []Inv {
 	return r.invs
 }
 
-// IsExpired returns true if the request has expired
-func (r RequestRecord) IsExpired() bool {
-	return time.Unix(r.timestamp, 0).Add(AvalancheRequestTimeout).Before(clock.Now())
+// IsExpired returns true if the request is older than the given timeout
+func (r RequestRecord) IsExpired(timeout time.Duration) bool {
+	return r.isExpiredAt(clock.Now(), timeout)
+}
+
+// isExpiredAt is IsExpired at the given time
+func (r RequestRecord) isExpiredAt(now time.Time, timeout time.Duration) bool {
+	return time.Unix(0, r.timestamp).Add(timeout).Before(now)
 }
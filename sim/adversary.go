@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"math/rand"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+// Vote values as they appear in a Response
+const (
+	voteYes uint32 = 0
+	voteNo  uint32 = 1
+)
+
+// Poll is a poll arriving at a node, along with the Response the node would
+// give if it were honest
+type Poll struct {
+	From   avalanche.NodeID
+	To     avalanche.NodeID
+	Round  int64
+	Invs   []avalanche.Inv
+	Honest avalanche.Response
+
+	// Rand is the Simulation's seeded source of randomness
+	Rand *rand.Rand
+}
+
+// Behavior decides how a node answers a poll. Returning false leaves the poll
+// unanswered.
+type Behavior func(s *Simulation, poll Poll) (avalanche.Response, bool)
+
+// Honest answers every poll with the node's actual preferences
+func Honest(_ *Simulation, poll Poll) (avalanche.Response, bool) {
+	return poll.Honest, true
+}
+
+// AlwaysNo votes against every target
+func AlwaysNo(_ *Simulation, poll Poll) (avalanche.Response, bool) {
+	return withVotes(poll, func(avalanche.Inv) uint32 { return voteNo }), true
+}
+
+// RandomFlip answers honestly but flips each yes or no vote with probability p
+func RandomFlip(p float64) Behavior {
+	return func(_ *Simulation, poll Poll) (avalanche.Response, bool) {
+		votes := poll.Honest.GetVotes()
+		return withVotes(poll, func(inv avalanche.Inv) uint32 {
+			v := voteFor(votes, inv.TargetHash)
+			if v <= voteNo && poll.Rand.Float64() < p {
+				return v ^ 1
+			}
+			return v
+		}), true
+	}
+}
+
+// Equivocate tells different pollers different things: pollers with even ids
+// are told yes to everything and pollers with odd ids are told no
+func Equivocate(_ *Simulation, poll Poll) (avalanche.Response, bool) {
+	vote := uint32(poll.From) & 1
+	return withVotes(poll, func(avalanche.Inv) uint32 { return vote }), true
+}
+
+// Silent answers honestly except that it ignores polls with probability p,
+// which makes pollers wait for their queries to time out
+func Silent(p float64) Behavior {
+	return func(_ *Simulation, poll Poll) (avalanche.Response, bool) {
+		if poll.Rand.Float64() < p {
+			return avalanche.Response{}, false
+		}
+		return poll.Honest, true
+	}
+}
+
+// SilentTo ignores every poll from the nodes the function selects and answers
+// the others honestly
+func SilentTo(ignore func(from avalanche.NodeID) bool) Behavior {
+	return func(_ *Simulation, poll Poll) (avalanche.Response, bool) {
+		if ignore(poll.From) {
+			return avalanche.Response{}, false
+		}
+		return poll.Honest, true
+	}
+}
+
+// Balance tries to keep the honest nodes split by always voting for whichever
+// side of each target fewer honest nodes currently prefer
+func Balance(s *Simulation, poll Poll) (avalanche.Response, bool) {
+	return withVotes(poll, func(inv avalanche.Inv) uint32 {
+		accepting, total := s.HonestPreference(inv.TargetHash)
+		if accepting*2 < total {
+			return voteYes
+		}
+		return voteNo
+	}), true
+}
+
+// withVotes builds a Response to the poll with the given vote for each inv
+func withVotes(poll Poll, vote func(avalanche.Inv) uint32) avalanche.Response {
+	votes := make([]avalanche.Vote, len(poll.Invs))
+	for i, inv := range poll.Invs {
+		votes[i] = avalanche.NewVote(vote(inv), inv.TargetHash)
+	}
+	return avalanche.NewResponse(poll.Round, poll.Honest.GetCooldown(), votes)
+}
+
+// voteFor returns the vote for the hash, or an unknown vote if there is none
+func voteFor(votes []avalanche.Vote, h avalanche.Hash) uint32 {
+	for _, v := range votes {
+		if v.GetHash() == h {
+			return v.GetError()
+		}
+	}
+	return ^uint32(0)
+}
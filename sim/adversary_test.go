@@ -0,0 +1,89 @@
+package sim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+func TestAdversaries(t *testing.T) {
+	tests := []struct {
+		name      string
+		adversary Behavior
+	}{
+		{"always no", AlwaysNo},
+		{"random flip", RandomFlip(0.5)},
+		{"equivocate", Equivocate},
+		{"silent", Silent(0.5)},
+		{"silent to odd", SilentTo(func(from avalanche.NodeID) bool { return from&1 == 1 })},
+		{"balance", Balance},
+	}
+
+	for _, test := range tests {
+		run := func() Result {
+			s, err := New(Config{
+				Nodes:     20,
+				Seed:      7,
+				Latency:   UniformLatency(10*time.Millisecond, 100*time.Millisecond),
+				Processor: avalanche.Config{FinalizationScore: 32, RequestTimeout: time.Second},
+				Byzantine: 0.2,
+				Adversary: test.adversary,
+			})
+			if err != nil {
+				t.Fatal("Failed to create simulation:", err)
+			}
+
+			// The honest nodes start out split on each target
+			for i := 0; i < 4; i++ {
+				h := txHash(i)
+				s.Submit(func(id avalanche.NodeID) avalanche.Target {
+					return &Tx{TxHash: h, Accepted: int(id)%2 == i%2}
+				})
+			}
+			return s.Run(30 * time.Minute)
+		}
+
+		result := run()
+		if len(result.Byzantine) != 4 {
+			t.Fatal(test.name, "- Expected 4 byzantine nodes but got", len(result.Byzantine))
+		}
+		if !result.Done {
+			t.Fatal(test.name, "- Not every honest node finalized every target")
+		}
+		if !result.Agreed() {
+			t.Fatal(test.name, "- Honest nodes did not agree")
+		}
+		for _, id := range result.Byzantine {
+			for h, decisions := range result.Decisions {
+				if _, ok := decisions[id]; ok {
+					t.Fatal(test.name, "- Byzantine node", id, "has a decision for", h)
+				}
+			}
+		}
+
+		if again := run(); !reflect.DeepEqual(result, again) {
+			t.Fatal(test.name, "- Runs with the same seed differ")
+		}
+	}
+}
+
+func TestSilentAdversary(t *testing.T) {
+	s, err := New(Config{Nodes: 10, Byzantine: 0.5, Adversary: Silent(1)})
+	if err != nil {
+		t.Fatal("Failed to create simulation:", err)
+	}
+	s.Submit(func(avalanche.NodeID) avalanche.Target { return &Tx{TxHash: txHash(1), Accepted: true} })
+
+	result := s.Run(time.Minute)
+	if result.Ignored == 0 {
+		t.Fatal("Expected polls to be ignored. Got:", result.Stats)
+	}
+
+	for _, fraction := range []float64{-0.1, 1} {
+		if _, err := New(Config{Nodes: 4, Byzantine: fraction}); err != ErrInvalidByzantine {
+			t.Fatal("Expected", ErrInvalidByzantine, "but got", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+// LatencyFunc returns how long a message takes to travel from one node to
+// another. It may use r, which is seeded by the Simulation, for randomness.
+type LatencyFunc func(r *rand.Rand, from, to avalanche.NodeID) time.Duration
+
+// DropFunc returns the probability that a message from one node to another is
+// lost
+type DropFunc func(from, to avalanche.NodeID) float64
+
+// ConstantLatency delays every message by d
+func ConstantLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand, avalanche.NodeID, avalanche.NodeID) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency delays every message by a random amount in [low, high)
+func UniformLatency(low, high time.Duration) LatencyFunc {
+	return func(r *rand.Rand, _, _ avalanche.NodeID) time.Duration {
+		if high <= low {
+			return low
+		}
+		return low + time.Duration(r.Int63n(int64(high-low)))
+	}
+}
+
+// ExponentialLatency delays every message by base plus an exponentially
+// distributed amount with the given mean, which gives a long tail of slow
+// messages
+func ExponentialLatency(base, mean time.Duration) LatencyFunc {
+	return func(r *rand.Rand, _, _ avalanche.NodeID) time.Duration {
+		extra := r.ExpFloat64() * float64(mean)
+		if extra > math.MaxInt64/2 {
+			extra = math.MaxInt64 / 2
+		}
+		return base + time.Duration(extra)
+	}
+}
+
+// UniformDrop loses every message with probability p
+func UniformDrop(p float64) DropFunc {
+	return func(avalanche.NodeID, avalanche.NodeID) float64 {
+		return p
+	}
+}
+
+// transport carries a node's polls over the simulated network
+type transport struct {
+	sim *Simulation
+	id  avalanche.NodeID
+}
+
+// SendPoll implements the avalanche.Transport interface by scheduling the
+// poll's arrival at the other node
+func (t *transport) SendPoll(id avalanche.NodeID, round int64, invs []avalanche.Inv) error {
+	if int(id) < 0 || int(id) >= len(t.sim.nodes) {
+		return avalanche.ErrUnknownNode
+	}
+
+	t.sim.stats.Polls++
+	t.sim.send(t.id, id, func() {
+		t.sim.deliverPoll(t.id, id, round, invs)
+	})
+	return nil
+}
+
+// Responses implements the avalanche.Transport interface. Responses are
+// registered by the Simulation as they arrive, so none are delivered here.
+func (*transport) Responses() <-chan avalanche.IncomingResponse {
+	return nil
+}
@@ -0,0 +1,68 @@
+package sim
+
+import (
+	"container/heap"
+	"time"
+)
+
+// event is something that happens at a point in virtual time
+type event struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+// eventQueue orders events by time, and events at the same time by when they
+// were scheduled, so that runs are reproducible
+type eventQueue []*event
+
+// Len implements the heap interface
+func (q eventQueue) Len() int { return len(q) }
+
+// Less implements the heap interface
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+// Swap implements the heap interface
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+// Push implements the heap interface
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+// Pop implements the heap interface
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// scheduler runs events in virtual time
+type scheduler struct {
+	now   time.Duration
+	seq   uint64
+	queue eventQueue
+}
+
+// after schedules fn to run once the delay has passed
+func (s *scheduler) after(delay time.Duration, fn func()) {
+	s.seq++
+	heap.Push(&s.queue, &event{s.now + delay, s.seq, fn})
+}
+
+// next runs the earliest event, advancing the time to it. It returns false if
+// there are no events before the deadline.
+func (s *scheduler) next(deadline time.Duration) bool {
+	if len(s.queue) == 0 || s.queue[0].at > deadline {
+		return false
+	}
+
+	e := heap.Pop(&s.queue).(*event)
+	s.now = e.at
+	e.fn()
+	return true
+}
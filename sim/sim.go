@@ -0,0 +1,386 @@
+// Package sim runs many avalanche Processors over a simulated network in
+// virtual time. Message latency and loss are configurable per link, and all
+// randomness comes from a single seed so that the same seed always reproduces
+// the same run.
+package sim
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+const (
+	// DefaultNodes is the number of nodes simulated if none is given
+	DefaultNodes = 16
+
+	// DefaultLatency is the one-way latency of every link if none is given
+	DefaultLatency = 50 * time.Millisecond
+)
+
+var (
+	// ErrInvalidNodes is returned when configuring a Simulation with too few
+	// nodes
+	ErrInvalidNodes = errors.New("sim: at least two nodes are required")
+
+	// ErrInvalidByzantine is returned when the fraction of Byzantine nodes is
+	// not in [0, 1)
+	ErrInvalidByzantine = errors.New("sim: byzantine fraction must be at least 0 and less than 1")
+)
+
+// epoch is the wall clock time that virtual time starts at
+var epoch = time.Unix(1500000000, 0)
+
+// Config holds the parameters of a Simulation
+type Config struct {
+	// Nodes is the number of nodes in the network
+	Nodes int
+
+	// Seed is the seed all randomness in the run is derived from
+	Seed int64
+
+	// Latency returns the delay of each message. Defaults to DefaultLatency
+	// for every link.
+	Latency LatencyFunc
+
+	// Drop returns the probability that each message is lost. Defaults to no
+	// loss.
+	Drop DropFunc
+
+	// Processor is the Config of every node's Processor
+	Processor avalanche.Config
+
+	// Byzantine is the fraction of nodes, chosen at random, that answer polls
+	// with the Adversary's Behavior instead of honestly
+	Byzantine float64
+
+	// Adversary is the Behavior of the Byzantine nodes. Defaults to AlwaysNo.
+	Adversary Behavior
+}
+
+// Stats counts the messages sent during a run
+type Stats struct {
+	Polls     int
+	Responses int
+	Dropped   int
+
+	// Ignored is the number of polls that were left unanswered
+	Ignored int
+}
+
+// Result is the outcome of a run
+type Result struct {
+	Stats
+
+	// Elapsed is the amount of virtual time the run took
+	Elapsed time.Duration
+
+	// Done is true if every honest node finalized every target
+	Done bool
+
+	// Decisions maps each target to whether each honest node finalized it as
+	// accepted. Nodes that did not finalize it are absent.
+	Decisions map[avalanche.Hash]map[avalanche.NodeID]bool
+
+	// Byzantine lists the nodes that were not Honest
+	Byzantine []avalanche.NodeID
+}
+
+// Agreed returns whether or not every honest node that finalized a target came
+// to the same decision about it
+func (r Result) Agreed() bool {
+	for _, decisions := range r.Decisions {
+		seen, first := false, true
+		for _, accepted := range decisions {
+			if !first && accepted != seen {
+				return false
+			}
+			seen, first = accepted, false
+		}
+	}
+	return true
+}
+
+// Node is a simulated node
+type Node struct {
+	ID        avalanche.NodeID
+	Processor *avalanche.Processor
+
+	// Behavior decides how the node answers polls
+	Behavior Behavior
+
+	// Byzantine is true if the node is not Honest
+	Byzantine bool
+}
+
+// Simulation is a network of nodes running in virtual time. It is not safe for
+// concurrent use.
+type Simulation struct {
+	cfg   Config
+	rand  *rand.Rand
+	sched scheduler
+	nodes []*Node
+	stats Stats
+
+	targets   []avalanche.Hash
+	decisions map[avalanche.Hash]map[avalanche.NodeID]bool
+}
+
+// New creates a *Simulation of a fully connected network of equally weighted
+// nodes
+func New(cfg Config) (*Simulation, error) {
+	if cfg.Nodes == 0 {
+		cfg.Nodes = DefaultNodes
+	}
+	if cfg.Nodes < 2 {
+		return nil, ErrInvalidNodes
+	}
+	if cfg.Latency == nil {
+		cfg.Latency = ConstantLatency(DefaultLatency)
+	}
+	if cfg.Drop == nil {
+		cfg.Drop = UniformDrop(0)
+	}
+	if cfg.Byzantine < 0 || cfg.Byzantine >= 1 {
+		return nil, ErrInvalidByzantine
+	}
+	if cfg.Adversary == nil {
+		cfg.Adversary = AlwaysNo
+	}
+
+	s := &Simulation{
+		cfg:       cfg,
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		decisions: map[avalanche.Hash]map[avalanche.NodeID]bool{},
+	}
+
+	for i := 0; i < cfg.Nodes; i++ {
+		id := avalanche.NodeID(i)
+
+		connman := avalanche.NewConnman()
+		for j := 0; j < cfg.Nodes; j++ {
+			if j != i {
+				connman.AddNode(avalanche.NodeID(j))
+			}
+		}
+
+		p, err := avalanche.NewProcessor(connman, cfg.Processor,
+			avalanche.WithClock(s.Now),
+			avalanche.WithRand(rand.New(rand.NewSource(s.rand.Int63()))),
+			avalanche.WithTransport(&transport{s, id}))
+		if err != nil {
+			return nil, err
+		}
+
+		p.Subscribe(avalanche.UpdateFilter{}, func(u avalanche.StatusUpdate) {
+			s.record(id, u)
+		})
+
+		s.nodes = append(s.nodes, &Node{ID: id, Processor: p, Behavior: Honest})
+	}
+
+	if byzantine := int(cfg.Byzantine * float64(cfg.Nodes)); byzantine > 0 {
+		for _, i := range s.rand.Perm(cfg.Nodes)[:byzantine] {
+			s.nodes[i].Behavior = cfg.Adversary
+			s.nodes[i].Byzantine = true
+		}
+	}
+
+	// Stagger the nodes so they don't all poll at the same instant
+	step := s.nodes[0].Processor.Config().TimeStep
+	for _, n := range s.nodes {
+		n := n
+		s.sched.after(time.Duration(s.rand.Int63n(int64(step))), func() { s.tick(n, step) })
+	}
+
+	return s, nil
+}
+
+// Nodes returns the nodes in the network
+func (s *Simulation) Nodes() []*Node {
+	return s.nodes
+}
+
+// Now returns the current virtual time
+func (s *Simulation) Now() time.Time {
+	return epoch.Add(s.sched.now)
+}
+
+// Elapsed returns the amount of virtual time since the start of the run
+func (s *Simulation) Elapsed() time.Duration {
+	return s.sched.now
+}
+
+// Submit adds a target to every node now. targetFor returns the node's view of
+// the target, which lets nodes start out with different preferences.
+func (s *Simulation) Submit(targetFor func(id avalanche.NodeID) avalanche.Target) {
+	var h avalanche.Hash
+	for _, n := range s.nodes {
+		t := targetFor(n.ID)
+		h = t.Hash()
+		n.Processor.AddTargetToReconcile(t)
+	}
+
+	if _, ok := s.decisions[h]; !ok {
+		s.targets = append(s.targets, h)
+		s.decisions[h] = map[avalanche.NodeID]bool{}
+	}
+}
+
+// Run processes events until every node has finalized every target or the
+// given amount of virtual time has passed
+func (s *Simulation) Run(limit time.Duration) Result {
+	deadline := s.sched.now + limit
+	for !s.done() {
+		if !s.sched.next(deadline) {
+			break
+		}
+	}
+
+	return s.result()
+}
+
+// tick runs an iteration of the node's event loop and schedules the next one
+func (s *Simulation) tick(n *Node, step time.Duration) {
+	n.Processor.Tick()
+	s.sched.after(step, func() { s.tick(n, step) })
+}
+
+// send schedules the delivery of a message unless it is lost
+func (s *Simulation) send(from, to avalanche.NodeID, deliver func()) {
+	if s.rand.Float64() < s.cfg.Drop(from, to) {
+		s.stats.Dropped++
+		return
+	}
+	s.sched.after(s.cfg.Latency(s.rand, from, to), deliver)
+}
+
+// HonestPreference returns how many honest nodes currently prefer to accept
+// the target, out of how many honest nodes there are
+func (s *Simulation) HonestPreference(h avalanche.Hash) (accepting, total int) {
+	for _, n := range s.nodes {
+		if n.Byzantine {
+			continue
+		}
+		total++
+
+		if accepted, ok := n.Processor.GetFinalized(h); ok {
+			if accepted {
+				accepting++
+			}
+			continue
+		}
+		if n.Processor.IsAccepted(&Tx{TxHash: h}) {
+			accepting++
+		}
+	}
+	return accepting, total
+}
+
+// deliverPoll has a node answer a poll and sends the response back
+func (s *Simulation) deliverPoll(from, to avalanche.NodeID, round int64, invs []avalanche.Inv) {
+	n := s.nodes[to]
+	honest, err := n.Processor.Respond(round, invs)
+	if err != nil {
+		s.stats.Ignored++
+		return
+	}
+
+	resp, ok := n.Behavior(s, Poll{
+		From:   from,
+		To:     to,
+		Round:  round,
+		Invs:   invs,
+		Honest: honest,
+		Rand:   s.rand,
+	})
+	if !ok {
+		s.stats.Ignored++
+		return
+	}
+
+	s.stats.Responses++
+	s.send(to, from, func() {
+		// Responses to expired queries are rejected like on a real network
+		_ = s.nodes[from].Processor.RegisterVotes(to, resp, &[]avalanche.StatusUpdate{})
+	})
+}
+
+// record remembers an honest node's final decision about a target
+func (s *Simulation) record(id avalanche.NodeID, u avalanche.StatusUpdate) {
+	if u.Status != avalanche.StatusFinalized && u.Status != avalanche.StatusInvalid {
+		return
+	}
+	if s.nodes[id].Byzantine {
+		return
+	}
+	if decisions, ok := s.decisions[u.Hash]; ok {
+		decisions[id] = u.Status == avalanche.StatusFinalized
+	}
+}
+
+// done returns whether or not every honest node has finalized every target
+func (s *Simulation) done() bool {
+	honest := 0
+	for _, n := range s.nodes {
+		if !n.Byzantine {
+			honest++
+		}
+	}
+
+	for _, h := range s.targets {
+		if len(s.decisions[h]) < honest {
+			return false
+		}
+	}
+	return len(s.targets) > 0
+}
+
+// result summarizes the run so far
+func (s *Simulation) result() Result {
+	decisions := make(map[avalanche.Hash]map[avalanche.NodeID]bool, len(s.decisions))
+	for h, d := range s.decisions {
+		decisions[h] = make(map[avalanche.NodeID]bool, len(d))
+		for id, accepted := range d {
+			decisions[h][id] = accepted
+		}
+	}
+
+	var byzantine []avalanche.NodeID
+	for _, n := range s.nodes {
+		if n.Byzantine {
+			byzantine = append(byzantine, n.ID)
+		}
+	}
+
+	return Result{
+		Stats:     s.stats,
+		Elapsed:   s.sched.now,
+		Done:      s.done(),
+		Decisions: decisions,
+		Byzantine: byzantine,
+	}
+}
+
+// Tx is a simple target for simulations
+type Tx struct {
+	TxHash   avalanche.Hash
+	Accepted bool
+}
+
+// Hash implements the avalanche.Target interface
+func (tx *Tx) Hash() avalanche.Hash { return tx.TxHash }
+
+// Type implements the avalanche.Target interface
+func (*Tx) Type() string { return "tx" }
+
+// IsAccepted implements the avalanche.Target interface
+func (tx *Tx) IsAccepted() bool { return tx.Accepted }
+
+// Score implements the avalanche.Target interface
+func (*Tx) Score() int64 { return 1 }
+
+// IsValid implements the avalanche.Target interface
+func (*Tx) IsValid() bool { return true }
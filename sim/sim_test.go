@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	avalanche "github.com/tyler-smith/go-avalanche"
+)
+
+func TestSimulation(t *testing.T) {
+	run := func(seed int64) Result {
+		s, err := New(Config{
+			Nodes:     12,
+			Seed:      seed,
+			Latency:   UniformLatency(10*time.Millisecond, 200*time.Millisecond),
+			Drop:      UniformDrop(0.05),
+			Processor: avalanche.Config{FinalizationScore: 32, RequestTimeout: time.Second},
+		})
+		if err != nil {
+			t.Fatal("Failed to create simulation:", err)
+		}
+
+		for i := 0; i < 10; i++ {
+			h := txHash(i)
+			s.Submit(func(id avalanche.NodeID) avalanche.Target {
+				return &Tx{TxHash: h, Accepted: true}
+			})
+		}
+		return s.Run(10 * time.Minute)
+	}
+
+	result := run(1)
+	if !result.Done {
+		t.Fatal("Not every node finalized every target")
+	}
+	if !result.Agreed() {
+		t.Fatal("Nodes did not agree")
+	}
+	for h, decisions := range result.Decisions {
+		for id, accepted := range decisions {
+			if !accepted {
+				t.Fatal("Node", id, "rejected", h)
+			}
+		}
+	}
+	if result.Dropped == 0 || result.Polls == 0 || result.Responses == 0 {
+		t.Fatal("Expected messages to be sent and dropped. Got:", result.Stats)
+	}
+
+	// The same seed reproduces the same run
+	if again := run(1); !reflect.DeepEqual(result, again) {
+		t.Fatal("Runs with the same seed differ:", result.Stats, result.Elapsed, again.Stats, again.Elapsed)
+	}
+
+	// A different seed gives a different run
+	if other := run(2); reflect.DeepEqual(result.Stats, other.Stats) && result.Elapsed == other.Elapsed {
+		t.Fatal("Runs with different seeds are the same")
+	}
+}
+
+func TestSimulationTimeLimit(t *testing.T) {
+	// Nothing gets through so nothing can be finalized
+	s, err := New(Config{Nodes: 4, Drop: UniformDrop(1)})
+	if err != nil {
+		t.Fatal("Failed to create simulation:", err)
+	}
+	s.Submit(func(avalanche.NodeID) avalanche.Target { return &Tx{TxHash: txHash(1), Accepted: true} })
+
+	result := s.Run(time.Second)
+	if result.Done || result.Elapsed > time.Second || result.Responses != 0 {
+		t.Fatal("Expected the run to stop at the time limit. Got:", result.Stats, result.Elapsed)
+	}
+
+	if _, err := New(Config{Nodes: 1}); err != ErrInvalidNodes {
+		t.Fatal("Expected", ErrInvalidNodes, "but got", err)
+	}
+}
+
+func txHash(i int) avalanche.Hash {
+	h := avalanche.Hash{}
+	binary.LittleEndian.PutUint64(h[:], uint64(i))
+	return h
+}
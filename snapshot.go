@@ -0,0 +1,267 @@
+package avalanche
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// WriteSnapshot
+const SnapshotVersion = 1
+
+// snapshotMagic identifies a snapshot file
+var snapshotMagic = [4]byte{'a', 'v', 's', 'n'}
+
+// maxSnapshotString is the longest inv type accepted when reading a snapshot
+const maxSnapshotString = 1 << 10
+
+var (
+	// ErrInvalidSnapshot is returned when reading data that is not a snapshot
+	ErrInvalidSnapshot = errors.New("avalanche: invalid snapshot")
+
+	// ErrUnsupportedSnapshotVersion is returned when reading a snapshot written
+	// in a format this version does not understand
+	ErrUnsupportedSnapshotVersion = errors.New("avalanche: unsupported snapshot version")
+)
+
+// WriteSnapshot writes the vote records, finalized hashes, and outstanding
+// queries of the Processor to w. Targets themselves are not saved; a restored
+// Processor resumes voting on a Target from where it left off once it is added
+// again with AddTargetToReconcile.
+//
+// The format is little-endian:
+//
+//	magic "avsn" | version uint16 | round int64
+//	count uint32 | count * (hash [32]byte | votes uint64 | consider uint64 | confidence uint16)
+//	count uint32 | count * (hash [32]byte | accepted uint8)
+//	count uint32 | count * (round int64 | node int64 | timestamp int64 (ns) | count uint32 |
+//	                        count * (type length uint16 | type []byte | hash [32]byte))
+func (p *Processor) WriteSnapshot(w io.Writer) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+
+	sw.write(snapshotMagic)
+	sw.write(uint16(SnapshotVersion))
+	sw.write(p.round)
+
+	// Records that were restored but not yet added again are kept as well
+	sw.write(uint32(len(p.voteRecords) + len(p.restored)))
+	for _, records := range []map[Hash]*VoteRecord{p.voteRecords, p.restored} {
+		for h, vr := range records {
+			sw.write(h)
+			sw.write(vr.votes)
+			sw.write(vr.consider)
+			sw.write(vr.confidence)
+		}
+	}
+
+	sw.write(uint32(len(p.finalized)))
+	for h, accepted := range p.finalized {
+		sw.write(h)
+		sw.write(boolToUint8(accepted))
+	}
+
+	sw.write(uint32(len(p.queries)))
+	for key, r := range p.queries {
+		sw.write(key.round)
+		sw.write(int64(key.nodeID))
+		sw.write(r.GetTimestamp())
+		sw.write(uint32(len(r.GetInvs())))
+		for _, inv := range r.GetInvs() {
+			sw.write(uint16(len(inv.TargetType)))
+			sw.write([]byte(inv.TargetType))
+			sw.write(inv.TargetHash)
+		}
+	}
+
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// SaveSnapshot writes a snapshot to the file at path. The file is replaced
+// atomically so that a crash never leaves a partial snapshot behind.
+func (p *Processor) SaveSnapshot(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := p.WriteSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// WithSnapshot has the Processor resume from a snapshot written by
+// WriteSnapshot. Vote records are applied with the Processor's Config when
+// their Targets are added again.
+func WithSnapshot(r io.Reader) Option {
+	return func(p *Processor) error {
+		return p.readSnapshot(r)
+	}
+}
+
+// WithSnapshotFile is WithSnapshot reading from the file at path
+func WithSnapshotFile(path string) Option {
+	return func(p *Processor) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return p.readSnapshot(f)
+	}
+}
+
+// readSnapshot loads the state in the snapshot into the Processor
+func (p *Processor) readSnapshot(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	var magic [4]byte
+	sr.read(&magic)
+	if sr.err != nil || magic != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+
+	var version uint16
+	sr.read(&version)
+	if sr.err == nil && version != SnapshotVersion {
+		return ErrUnsupportedSnapshotVersion
+	}
+
+	var round int64
+	sr.read(&round)
+
+	restored := map[Hash]*VoteRecord{}
+	for i, n := 0, sr.count(); i < n && sr.err == nil; i++ {
+		var h Hash
+		vr := NewVoteRecord(false, p.cfg)
+		sr.read(&h)
+		sr.read(&vr.votes)
+		sr.read(&vr.consider)
+		sr.read(&vr.confidence)
+
+		// Keep records that were not final under the old Config from being
+		// final under ours without another vote
+		if vr.getConfidence() >= vr.finalizationScore {
+			vr.confidence = (vr.finalizationScore-1)<<1 | vr.confidence&0x01
+		}
+		restored[h] = vr
+	}
+
+	finalized := map[Hash]bool{}
+	for i, n := 0, sr.count(); i < n && sr.err == nil; i++ {
+		var (
+			h        Hash
+			accepted uint8
+		)
+		sr.read(&h)
+		sr.read(&accepted)
+		finalized[h] = accepted != 0
+	}
+
+	queries := map[requestKey]RequestRecord{}
+	for i, n := 0, sr.count(); i < n && sr.err == nil; i++ {
+		var (
+			key       requestKey
+			node      int64
+			timestamp int64
+		)
+		sr.read(&key.round)
+		sr.read(&node)
+		sr.read(&timestamp)
+		key.nodeID = NodeID(node)
+
+		invs := []Inv{}
+		for j, m := 0, sr.count(); j < m && sr.err == nil; j++ {
+			inv := Inv{TargetType: sr.string()}
+			sr.read(&inv.TargetHash)
+			invs = append(invs, inv)
+		}
+		queries[key] = NewRequestRecord(timestamp, invs)
+	}
+
+	if sr.err != nil {
+		return ErrInvalidSnapshot
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.round = round
+	p.restored = restored
+	p.finalized = finalized
+	p.queries = queries
+	return nil
+}
+
+// snapshotWriter writes little-endian values, remembering the first error
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(v interface{}) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.w, binary.LittleEndian, v)
+	}
+}
+
+// snapshotReader reads little-endian values, remembering the first error
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (sr *snapshotReader) read(v interface{}) {
+	if sr.err == nil {
+		sr.err = binary.Read(sr.r, binary.LittleEndian, v)
+	}
+}
+
+// count reads a uint32 item count. Nothing is read after an error.
+func (sr *snapshotReader) count() int {
+	var n uint32
+	sr.read(&n)
+	if sr.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// string reads a length-prefixed string
+func (sr *snapshotReader) string() string {
+	var n uint16
+	sr.read(&n)
+	if sr.err != nil {
+		return ""
+	}
+	if n > maxSnapshotString {
+		sr.err = ErrInvalidSnapshot
+		return ""
+	}
+
+	b := make([]byte, n)
+	_, sr.err = io.ReadFull(sr.r, b)
+	return string(b)
+}
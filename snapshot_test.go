@@ -0,0 +1,98 @@
+package avalanche
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	var (
+		connman = NewConnman()
+		p       = newTestProcessor(t, connman)
+		nodeID  = NodeID(0)
+		updates = []StatusUpdate{}
+
+		pending   = &testTx{hash: Hash{1}, accepted: true}
+		finalized = &testTx{hash: Hash{2}, accepted: true}
+		rejected  = &testTx{hash: Hash{3}, accepted: true}
+	)
+	connman.AddNode(nodeID)
+
+	// Finalize one target and build up confidence in the others
+	assertTrue(t, p.AddTargetToReconcile(finalized))
+	for {
+		if _, ok := p.GetFinalized(finalized.Hash()); ok {
+			break
+		}
+		assertNoError(t, respondToPoll(p, nodeID, map[Hash]uint32{finalized.Hash(): 0}, &updates))
+	}
+
+	assertTrue(t, p.AddTargetToReconcile(pending))
+	assertTrue(t, p.AddTargetToReconcile(rejected))
+	votes := map[Hash]uint32{pending.Hash(): 0, rejected.Hash(): 1}
+	for i := 0; i < 20; i++ {
+		assertNoError(t, respondToPoll(p, nodeID, votes, &updates))
+	}
+	confidence := p.GetConfidence(pending)
+	assertTrue(t, confidence > 0)
+	assertFalse(t, p.IsAccepted(rejected))
+
+	// Leave a query outstanding
+	round, invs := p.StartQuery(nodeID)
+
+	buf := &bytes.Buffer{}
+	assertNoError(t, p.WriteSnapshot(buf))
+
+	restored, err := NewProcessor(connman, DefaultConfig(), WithSnapshot(bytes.NewReader(buf.Bytes())))
+	assertNoError(t, err)
+	assertTrue(t, restored.GetRound() == p.GetRound())
+
+	accepted, ok := restored.GetFinalized(finalized.Hash())
+	assertTrue(t, ok && accepted)
+
+	// Outcomes are kept until they are forgotten
+	restored.ForgetFinalized(finalized.Hash())
+	_, ok = restored.GetFinalized(finalized.Hash())
+	assertFalse(t, ok)
+
+	// Records resume once their targets are added again
+	assertTrue(t, restored.AddTargetToReconcile(pending))
+	assertTrue(t, restored.AddTargetToReconcile(rejected))
+	assertTrue(t, restored.GetConfidence(pending) == confidence)
+	assertFalse(t, restored.IsAccepted(rejected))
+
+	// The outstanding query can still be answered
+	resp := make([]Vote, len(invs))
+	for i, inv := range invs {
+		resp[i] = NewVote(votes[inv.TargetHash], inv.TargetHash)
+	}
+	assertNoError(t, restored.RegisterVotes(nodeID, NewResponse(round, 0, resp), &updates))
+	assertTrue(t, restored.GetConfidence(pending) == confidence+1)
+
+	// Snapshots can be saved to and loaded from disk
+	dir, err := ioutil.TempDir("", "avalanche")
+	assertNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot")
+	assertNoError(t, restored.SaveSnapshot(path))
+	fromFile, err := NewProcessor(connman, DefaultConfig(), WithSnapshotFile(path))
+	assertNoError(t, err)
+	assertTrue(t, fromFile.AddTargetToReconcile(pending))
+	assertTrue(t, fromFile.GetConfidence(pending) == confidence+1)
+
+	// Corrupt or unknown snapshots are refused
+	_, err = NewProcessor(connman, DefaultConfig(), WithSnapshot(bytes.NewReader([]byte("nope"))))
+	assertTrue(t, err == ErrInvalidSnapshot)
+
+	_, err = NewProcessor(connman, DefaultConfig(), WithSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-1])))
+	assertTrue(t, err == ErrInvalidSnapshot)
+
+	future := append([]byte{}, buf.Bytes()...)
+	future[4] = SnapshotVersion + 1
+	_, err = NewProcessor(connman, DefaultConfig(), WithSnapshot(bytes.NewReader(future)))
+	assertTrue(t, err == ErrUnsupportedSnapshotVersion)
+}
@@ -0,0 +1,235 @@
+package avalanche
+
+import (
+	"sort"
+	"sync"
+)
+
+// UpdateFilter selects the StatusUpdates a subscriber receives. Empty fields
+// match everything.
+type UpdateFilter struct {
+	// Types limits updates to Targets of these types
+	Types []string
+
+	// Hashes limits updates to Targets with these hashes
+	Hashes []Hash
+}
+
+// matches returns whether or not an update for a Target of the given type
+// passes the filter
+func (f UpdateFilter) matches(u StatusUpdate, targetType string) bool {
+	if len(f.Types) > 0 && !containsString(f.Types, targetType) {
+		return false
+	}
+	if len(f.Hashes) > 0 && !containsHash(f.Hashes, u.Hash) {
+		return false
+	}
+	return true
+}
+
+type subscription struct {
+	fn     func(StatusUpdate)
+	filter UpdateFilter
+}
+
+// Subscribe registers a function to be called with every StatusUpdate that
+// passes the filter, whether it was produced by RegisterVotes or by the event
+// loop. Updates are delivered one at a time in the order the changes were
+// made, after the change is visible through methods like IsAccepted. The
+// Processor's state is not locked while fn runs, so it may query the
+// Processor, but it must not register votes or subscribe. The updates caused
+// by a call to RegisterVotes have been delivered by the time it returns. The
+// returned function cancels the subscription.
+func (p *Processor) Subscribe(filter UpdateFilter, fn func(StatusUpdate)) (unsubscribe func()) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+
+	id := p.nextSubscriberID
+	p.nextSubscriberID++
+	p.subscribers[id] = subscription{fn, filter}
+
+	return func() {
+		p.subscribersMu.Lock()
+		defer p.subscribersMu.Unlock()
+
+		delete(p.subscribers, id)
+	}
+}
+
+// SubscribeChan is Subscribe delivering to a channel with the given buffer
+// size. Updates that do not fit in the buffer are queued in order by a
+// goroutine owned by the subscription, so a slow reader never holds up vote
+// processing, but the queue grows until the channel is drained. The channel is
+// closed once the subscription is cancelled; queued updates are discarded.
+func (p *Processor) SubscribeChan(filter UpdateFilter, size int) (<-chan StatusUpdate, func()) {
+	var (
+		ch   = make(chan StatusUpdate, size)
+		done = make(chan struct{})
+		q    = newUpdateQueue()
+	)
+
+	unsubscribe := p.Subscribe(filter, q.push)
+	go q.forward(ch, done)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+}
+
+// updateQueue is an unbounded FIFO of StatusUpdates
+type updateQueue struct {
+	mu      sync.Mutex
+	updates []StatusUpdate
+	signal  chan struct{}
+}
+
+func newUpdateQueue() *updateQueue {
+	return &updateQueue{signal: make(chan struct{}, 1)}
+}
+
+// push adds an update to the back of the queue without blocking
+func (q *updateQueue) push(u StatusUpdate) {
+	q.mu.Lock()
+	q.updates = append(q.updates, u)
+	q.mu.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the update at the front of the queue, if there is one
+func (q *updateQueue) pop() (StatusUpdate, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.updates) == 0 {
+		return StatusUpdate{}, false
+	}
+	u := q.updates[0]
+	q.updates = q.updates[1:]
+	return u, true
+}
+
+// forward sends queued updates to ch until done is closed, and then closes ch
+func (q *updateQueue) forward(ch chan<- StatusUpdate, done <-chan struct{}) {
+	defer close(ch)
+
+	for {
+		u, ok := q.pop()
+		if !ok {
+			select {
+			case <-q.signal:
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		select {
+		case ch <- u:
+		case <-done:
+			return
+		}
+	}
+}
+
+// pendingUpdate is a StatusUpdate waiting to be delivered, along with the type
+// of its Target
+type pendingUpdate struct {
+	update     StatusUpdate
+	targetType string
+}
+
+// enqueue queues the updates for delivery. The caller must hold mu so that
+// updates are queued in the order they were made.
+func (p *Processor) enqueue(updates []StatusUpdate, types []string) {
+	if len(updates) == 0 {
+		return
+	}
+
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+
+	for i, u := range updates {
+		p.pending = append(p.pending, pendingUpdate{u, types[i]})
+	}
+}
+
+// deliver publishes every queued update. The caller must not hold mu. Once it
+// returns, everything queued before it was called has been delivered, whether
+// by this call or by a concurrent one.
+func (p *Processor) deliver() {
+	p.notifyMu.Lock()
+	defer p.notifyMu.Unlock()
+
+	p.pendingMu.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.pendingMu.Unlock()
+
+	p.publish(pending)
+}
+
+// publish delivers the updates to the subscribers. The caller must hold
+// notifyMu.
+func (p *Processor) publish(updates []pendingUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+
+	// Subscribers are called in the order they subscribed
+	p.subscribersMu.Lock()
+	ids := make([]int, 0, len(p.subscribers))
+	for id := range p.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subs := make([]subscription, len(ids))
+	for i, id := range ids {
+		subs[i] = p.subscribers[id]
+	}
+	p.subscribersMu.Unlock()
+
+	for _, u := range updates {
+		for _, sub := range subs {
+			if sub.filter.matches(u.update, u.targetType) {
+				sub.fn(u.update)
+			}
+		}
+	}
+}
+
+// targetTypes returns the type of the Target of each update
+func (p *Processor) targetTypes(updates []StatusUpdate) []string {
+	types := make([]string, len(updates))
+	for i, u := range updates {
+		if t, ok := p.targets[u.Hash]; ok {
+			types[i] = t.Type()
+		}
+	}
+	return types
+}
+
+func containsString(strs []string, s string) bool {
+	for _, other := range strs {
+		if other == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsHash(hashes []Hash, h Hash) bool {
+	for _, other := range hashes {
+		if other == h {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package avalanche
+
+import (
+	"sort"
+	"time"
+)
+
+// QueryTimeoutFunc is called for each query that expires without a response
+type QueryTimeoutFunc func(id NodeID, round int64, invs []Inv)
+
+// timedOutQuery is a query removed from the outstanding queries because it
+// expired
+type timedOutQuery struct {
+	key  requestKey
+	invs []Inv
+}
+
+// sweepQueries removes the queries that have expired without a response and
+// counts a timeout against each node that failed to answer. Old answered and
+// expired queries are forgotten as well so that they do not pile up while no
+// new queries are made.
+func (p *Processor) sweepQueries() []timedOutQuery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	swept := []timedOutQuery{}
+	for key, r := range p.queries {
+		if !p.isExpired(r) {
+			continue
+		}
+
+		delete(p.queries, key)
+		p.expired[key] = r.GetTimestamp()
+		p.timeouts[key.nodeID]++
+		p.totalTimeouts++
+		p.metrics.queryTimedOut()
+
+		swept = append(swept, timedOutQuery{key, r.GetInvs()})
+	}
+	p.forgetOldQueries(p.now())
+
+	// Requeue in a deterministic order
+	sort.Slice(swept, func(i, j int) bool {
+		a, b := swept[i].key, swept[j].key
+		return a.round < b.round || a.round == b.round && a.nodeID < b.nodeID
+	})
+
+	return swept
+}
+
+// requeue polls another node about the Invs of a timed out query that still
+// need votes. It returns the query that was started, if any, so that it can be
+// sent.
+func (p *Processor) requeue(q timedOutQuery) (NodeID, int64, []Inv) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	invs := make([]Inv, 0, len(q.invs))
+	for _, inv := range q.invs {
+		vr, ok := p.voteRecords[inv.TargetHash]
+		if !ok || vr.hasFinalized() || !p.isWorthyPolling(p.targets[inv.TargetHash]) {
+			continue
+		}
+		invs = append(invs, inv)
+	}
+	if len(invs) == 0 {
+		return NoNode, p.round, nil
+	}
+
+	id := p.getSuitableNodeToQueryExcept(q.key.nodeID)
+	if id == NoNode {
+		return id, p.round, nil
+	}
+
+	round, invs := p.recordQuery(id, invs)
+	return id, round, invs
+}
+
+// GetTimeouts returns the number of queries to the node that have expired
+// since it last gave a valid response
+func (p *Processor) GetTimeouts(id NodeID) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.timeouts[id]
+}
+
+// GetTotalTimeouts returns the number of queries that have expired without a
+// response
+func (p *Processor) GetTotalTimeouts() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.totalTimeouts
+}
+
+// penalizedWeight halves the node's weight for each query it has let expire
+// since its last valid response. It never drops below one so that the node
+// can still be polled and redeem itself.
+func (p *Processor) penalizedWeight(id NodeID, weight uint64) uint64 {
+	timeouts := p.timeouts[id]
+	if timeouts >= 64 {
+		return 1
+	}
+
+	weight >>= uint(timeouts)
+	if weight == 0 {
+		return 1
+	}
+	return weight
+}
+
+// forgetOldQueries drops answered queries once any duplicate response to them
+// would have expired, and expired queries once they have been expired for as
+// long again
+func (p *Processor) forgetOldQueries(now time.Time) {
+	for key, timestamp := range p.answered {
+		if time.Unix(0, timestamp).Add(p.cfg.RequestTimeout).Before(now) {
+			delete(p.answered, key)
+		}
+	}
+
+	for key, timestamp := range p.expired {
+		if time.Unix(0, timestamp).Add(2 * p.cfg.RequestTimeout).Before(now) {
+			delete(p.expired, key)
+		}
+	}
+}
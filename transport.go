@@ -0,0 +1,117 @@
+package avalanche
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnknownNode is returned when sending to a node that cannot be reached
+var ErrUnknownNode = errors.New("avalanche: unknown node")
+
+// Transport carries polls from a Processor to other nodes and carries their
+// responses back
+type Transport interface {
+	// SendPoll sends a poll for the given Invs to the node. It must not wait
+	// for the node to respond.
+	SendPoll(id NodeID, round int64, invs []Inv) error
+
+	// Responses returns the channel on which responses from nodes are
+	// delivered
+	Responses() <-chan IncomingResponse
+}
+
+// IncomingResponse is a Response along with the node that sent it
+type IncomingResponse struct {
+	NodeID   NodeID
+	Response Response
+}
+
+// Responder answers a poll from another node
+type Responder func(from NodeID, round int64, invs []Inv) Response
+
+// MemoryNetwork connects MemoryTransports within a single process. It is
+// intended for tests and simulations.
+type MemoryNetwork struct {
+	mu         sync.RWMutex
+	transports map[NodeID]*MemoryTransport
+}
+
+// NewMemoryNetwork creates a new empty *MemoryNetwork
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{transports: map[NodeID]*MemoryTransport{}}
+}
+
+// NewTransport creates a *MemoryTransport for the node and attaches it to the
+// network. Polls sent to the node are answered by the Responder.
+func (n *MemoryNetwork) NewTransport(id NodeID, responder Responder) *MemoryTransport {
+	t := &MemoryTransport{
+		id:        id,
+		network:   n,
+		responder: responder,
+		responses: make(chan IncomingResponse, 64),
+		closed:    make(chan struct{}),
+	}
+
+	n.mu.Lock()
+	n.transports[id] = t
+	n.mu.Unlock()
+
+	return t
+}
+
+// transport returns the attached *MemoryTransport for the node, if any
+func (n *MemoryNetwork) transport(id NodeID) (*MemoryTransport, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	t, ok := n.transports[id]
+	return t, ok
+}
+
+// MemoryTransport is a channel-based Transport attached to a MemoryNetwork
+type MemoryTransport struct {
+	id        NodeID
+	network   *MemoryNetwork
+	responder Responder
+	responses chan IncomingResponse
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// SendPoll implements the Transport interface. The poll is answered on its own
+// goroutine.
+func (t *MemoryTransport) SendPoll(id NodeID, round int64, invs []Inv) error {
+	peer, ok := t.network.transport(id)
+	if !ok {
+		return ErrUnknownNode
+	}
+
+	go func() {
+		resp := peer.responder(t.id, round, invs)
+
+		select {
+		case t.responses <- IncomingResponse{id, resp}:
+		case <-t.closed:
+		}
+	}()
+
+	return nil
+}
+
+// Responses implements the Transport interface
+func (t *MemoryTransport) Responses() <-chan IncomingResponse {
+	return t.responses
+}
+
+// Close detaches the transport from the network and drops any responses that
+// have not been delivered yet
+func (t *MemoryTransport) Close() {
+	t.closeOnce.Do(func() {
+		t.network.mu.Lock()
+		delete(t.network.transports, t.id)
+		t.network.mu.Unlock()
+
+		close(t.closed)
+	})
+}
@@ -0,0 +1,107 @@
+package avalanche
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	var (
+		network = NewMemoryNetwork()
+		connman = NewConnman()
+		polled  = make(chan []Inv, 64)
+
+		localID  = NodeID(0)
+		remoteID = NodeID(1)
+		tx       = &testTx{hash: Hash{1}, accepted: true}
+	)
+	connman.AddNode(remoteID)
+
+	// The remote node votes yes for everything
+	network.NewTransport(remoteID, func(from NodeID, round int64, invs []Inv) Response {
+		assertTrue(t, from == localID)
+		polled <- invs
+
+		votes := make([]Vote, len(invs))
+		for i, inv := range invs {
+			votes[i] = NewVote(0, inv.TargetHash)
+		}
+		return NewResponse(round, 0, votes)
+	})
+
+	transport := network.NewTransport(localID, nil)
+	defer transport.Close()
+
+	p, err := NewProcessor(connman, Config{FinalizationScore: 4}, WithTransport(transport))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+	updates, unsubscribe := p.SubscribeChan(UpdateFilter{}, 1)
+	defer unsubscribe()
+
+	// Polls are sent and responses registered until the target is finalized
+	assertNoError(t, p.Start(context.Background()))
+	select {
+	case u := <-updates:
+		assertTrue(t, u == StatusUpdate{tx.Hash(), StatusFinalized})
+	case <-time.After(5 * time.Second):
+		t.Fatal("Target was not finalized")
+	}
+	assertNoError(t, p.Stop(context.Background()))
+	assertTrue(t, len(p.GetInvsForNextPoll()) == 0)
+
+	invs := <-polled
+	assertTrue(t, len(invs) == 1 && invs[0].TargetHash == tx.Hash())
+
+	// Sending to a node that is not on the network fails
+	assertTrue(t, transport.SendPoll(NodeID(2), 0, nil) == ErrUnknownNode)
+}
+
+func TestMemoryTransportStopRegistersResponses(t *testing.T) {
+	var (
+		network = NewMemoryNetwork()
+		connman = NewConnman()
+		polled  = make(chan struct{}, 64)
+		release = make(chan struct{})
+
+		localID  = NodeID(0)
+		remoteID = NodeID(1)
+		tx       = &testTx{hash: Hash{1}, accepted: true}
+	)
+	connman.AddNode(remoteID)
+
+	// The remote node only answers once it is released
+	network.NewTransport(remoteID, func(_ NodeID, round int64, invs []Inv) Response {
+		polled <- struct{}{}
+		<-release
+		return NewResponse(round, 0, []Vote{NewVote(0, invs[0].TargetHash)})
+	})
+
+	transport := network.NewTransport(localID, nil)
+	defer transport.Close()
+
+	p, err := NewProcessor(connman, DefaultConfig(), WithTransport(transport))
+	assertNoError(t, err)
+	assertTrue(t, p.AddTargetToReconcile(tx))
+
+	assertNoError(t, p.Start(context.Background()))
+	<-polled
+
+	stopped := make(chan error)
+	go func() { stopped <- p.Stop(context.Background()) }()
+	time.Sleep(5 * DefaultTimeStep)
+	close(release)
+
+	// Stopping finishes once the peer answers rather than when the query
+	// times out
+	select {
+	case err := <-stopped:
+		assertNoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the outstanding query to time out")
+	}
+
+	// The response was registered
+	_, ok := p.answered[queryKey(0, remoteID)]
+	assertTrue(t, ok)
+}
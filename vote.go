@@ -1,5 +1,7 @@
 package avalanche
 
+import "math/bits"
+
 // Vote represents a single vote for a target
 type Vote struct {
 	err  uint32 // this is called "error" in abc for some reason
@@ -23,15 +25,25 @@ func (v Vote) GetError() uint32 {
 
 // VoteRecord keeps track of a series of votes for a target
 type VoteRecord struct {
-	votes      uint8
-	consider   uint8
+	votes      uint64
+	consider   uint64
 	confidence uint16
+
+	finalizationScore uint16
+	window            uint8
+	quorum            uint8
 }
 
 // NewVoteRecord instantiates a new base record for voting on a target
 // `accepted` indicates whether or not the initial state should be acceptance
-func NewVoteRecord(accepted bool) *VoteRecord {
-	return &VoteRecord{confidence: boolToUint16(accepted)}
+func NewVoteRecord(accepted bool, cfg Config) *VoteRecord {
+	cfg = cfg.withDefaults()
+	return &VoteRecord{
+		confidence:        boolToUint16(accepted),
+		finalizationScore: uint16(cfg.FinalizationScore),
+		window:            uint8(cfg.VoteWindow),
+		quorum:            uint8(cfg.VoteQuorum),
+	}
 }
 
 // isAccepted returns whether or not the voted state is acceptance or not
@@ -46,26 +58,26 @@ func (vr VoteRecord) getConfidence() uint16 {
 
 // hasFinalized returns whether or not the record has finalized a state
 func (vr VoteRecord) hasFinalized() bool {
-	return vr.getConfidence() >= AvalancheFinalizationScore
+	return vr.getConfidence() >= vr.finalizationScore
 }
 
 // regsiterVote adds a new vote for an item and update confidence accordingly.
 // Returns true if the acceptance or finalization state changed.
 func (vr *VoteRecord) regsiterVote(err uint32) bool {
-	vr.votes = (vr.votes << 1) | boolToUint8(err == 0)
-	vr.consider = (vr.consider << 1) | boolToUint8(int32(err) >= 0)
+	vr.votes = (vr.votes << 1) | boolToUint64(err == 0)
+	vr.consider = (vr.consider << 1) | boolToUint64(int32(err) >= 0)
 
-	yes := countBits8(vr.votes&vr.consider&0xff) > 6
+	yes := vr.hasYesQuorum()
 
 	// The round is inconclusive
-	if !yes && countBits8((-vr.votes-1)&vr.consider&0xff) <= 6 {
+	if !yes && bits.OnesCount64(^vr.votes&vr.consider&vr.windowMask()) < int(vr.quorum) {
 		return false
 	}
 
 	// Vote is conclusive and agrees with our current state
 	if vr.isAccepted() == yes {
 		vr.confidence += 2
-		return vr.getConfidence() == AvalancheFinalizationScore
+		return vr.getConfidence() == vr.finalizationScore
 	}
 
 	// Vote is conclusive but does not agree with our current state
@@ -74,6 +86,23 @@ func (vr *VoteRecord) regsiterVote(err uint32) bool {
 	return true
 }
 
+// hasYesQuorum returns whether or not the votes in the window currently reach
+// the quorum for acceptance
+func (vr VoteRecord) hasYesQuorum() bool {
+	return bits.OnesCount64(vr.votes&vr.consider&vr.windowMask()) >= int(vr.quorum)
+}
+
+// holdFinalization steps the confidence back so that the record sits just
+// below finalization until it is allowed to finalize
+func (vr *VoteRecord) holdFinalization() {
+	vr.confidence -= 2
+}
+
+// reject moves the record to the rejected state with no confidence
+func (vr *VoteRecord) reject() {
+	vr.confidence = 0
+}
+
 func (vr *VoteRecord) status() (status Status) {
 	finalized := vr.hasFinalized()
 	accepted := vr.isAccepted()
@@ -90,11 +119,12 @@ func (vr *VoteRecord) status() (status Status) {
 	return status
 }
 
-func countBits8(i uint8) (count int) {
-	for ; i > 0; i &= (i - 1) {
-		count++
+// windowMask returns a bitmask covering the votes inside the sample window
+func (vr VoteRecord) windowMask() uint64 {
+	if vr.window >= 64 {
+		return ^uint64(0)
 	}
-	return count
+	return (uint64(1) << vr.window) - 1
 }
 
 func boolToUint8(b bool) uint8 {
@@ -107,3 +137,11 @@ func boolToUint8(b bool) uint8 {
 func boolToUint16(b bool) uint16 {
 	return uint16(boolToUint8(b))
 }
+
+func boolToUint32(b bool) uint32 {
+	return uint32(boolToUint8(b))
+}
+
+func boolToUint64(b bool) uint64 {
+	return uint64(boolToUint8(b))
+}
@@ -0,0 +1,283 @@
+package avalanche
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Inv type codes used on the wire; the same as Bitcoin's
+const (
+	// InvTypeTx is the wire code for Targets of type TypeTx
+	InvTypeTx uint32 = 1
+
+	// InvTypeBlock is the wire code for Targets of type TypeBlock
+	InvTypeBlock uint32 = 2
+)
+
+// MaxWireElements is the largest number of invs or votes accepted in a single
+// message
+const MaxWireElements = DefaultMaxElementPoll
+
+var (
+	// ErrUnknownInvType is returned when encoding or decoding an inv whose
+	// type has no wire code
+	ErrUnknownInvType = errors.New("avalanche: unknown inv type")
+
+	// ErrTooManyElements is returned when a message has more than
+	// MaxWireElements invs or votes
+	ErrTooManyElements = errors.New("avalanche: too many elements in message")
+
+	// ErrNonCanonicalCompactSize is returned when a length is not encoded in
+	// its shortest form
+	ErrNonCanonicalCompactSize = errors.New("avalanche: non-canonical compact size")
+)
+
+var (
+	invTypeCodes = map[string]uint32{TypeTx: InvTypeTx, TypeBlock: InvTypeBlock}
+	invTypeNames = map[uint32]string{InvTypeTx: TypeTx, InvTypeBlock: TypeBlock}
+)
+
+// EncodePoll writes a poll in the layout of Bitcoin ABC's avapoll message:
+//
+//	round uint64 | compact size count | count * (type uint32 | hash [32]byte)
+func EncodePoll(w io.Writer, round int64, invs []Inv) error {
+	if len(invs) > MaxWireElements {
+		return ErrTooManyElements
+	}
+
+	buf := make([]byte, 0, 8+9+len(invs)*(4+HashSize))
+	buf = appendUint64(buf, uint64(round))
+	buf = appendCompactSize(buf, uint64(len(invs)))
+	for _, inv := range invs {
+		code, ok := invTypeCodes[inv.TargetType]
+		if !ok {
+			return ErrUnknownInvType
+		}
+		buf = appendUint32(buf, code)
+		buf = append(buf, inv.TargetHash[:]...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// DecodePoll reads a poll written by EncodePoll
+func DecodePoll(r io.Reader) (int64, []Inv, error) {
+	round, err := readUint64(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n, err := readElementCount(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	invs := make([]Inv, n)
+	for i := range invs {
+		code, err := readUint32(r)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		name, ok := invTypeNames[code]
+		if !ok {
+			return 0, nil, ErrUnknownInvType
+		}
+		invs[i].TargetType = name
+
+		if _, err := io.ReadFull(r, invs[i].TargetHash[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return int64(round), invs, nil
+}
+
+// EncodeResponse writes a Response in the layout of Bitcoin ABC's avaresponse
+// message:
+//
+//	round uint64 | cooldown uint32 | compact size count | count * (error uint32 | hash [32]byte)
+func EncodeResponse(w io.Writer, resp Response) error {
+	votes := resp.GetVotes()
+	if len(votes) > MaxWireElements {
+		return ErrTooManyElements
+	}
+
+	buf := make([]byte, 0, 8+4+9+len(votes)*(4+HashSize))
+	buf = appendUint64(buf, uint64(resp.GetRound()))
+	buf = appendUint32(buf, resp.GetCooldown())
+	buf = appendCompactSize(buf, uint64(len(votes)))
+	for _, v := range votes {
+		h := v.GetHash()
+		buf = appendUint32(buf, v.GetError())
+		buf = append(buf, h[:]...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// DecodeResponse reads a Response written by EncodeResponse
+func DecodeResponse(r io.Reader) (Response, error) {
+	round, err := readUint64(r)
+	if err != nil {
+		return Response{}, err
+	}
+
+	cooldown, err := readUint32(r)
+	if err != nil {
+		return Response{}, err
+	}
+
+	n, err := readElementCount(r)
+	if err != nil {
+		return Response{}, err
+	}
+
+	votes := make([]Vote, n)
+	for i := range votes {
+		if votes[i].err, err = readUint32(r); err != nil {
+			return Response{}, err
+		}
+		if _, err := io.ReadFull(r, votes[i].hash[:]); err != nil {
+			return Response{}, err
+		}
+	}
+
+	return NewResponse(int64(round), cooldown, votes), nil
+}
+
+// EncodeSignedResponse writes a Response followed by its signature, as Bitcoin
+// ABC sends them
+func EncodeSignedResponse(w io.Writer, resp Response) error {
+	if len(resp.GetSignature()) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+
+	if err := EncodeResponse(w, resp); err != nil {
+		return err
+	}
+
+	_, err := w.Write(resp.GetSignature())
+	return err
+}
+
+// DecodeSignedResponse reads a Response written by EncodeSignedResponse. The
+// signature is not verified.
+func DecodeSignedResponse(r io.Reader) (Response, error) {
+	resp, err := DecodeResponse(r)
+	if err != nil {
+		return Response{}, err
+	}
+
+	resp.signature = make([]byte, ed25519.SignatureSize)
+	if _, err := io.ReadFull(r, resp.signature); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// appendCompactSize appends n in Bitcoin's variable length integer encoding
+func appendCompactSize(buf []byte, n uint64) []byte {
+	switch {
+	case n < 0xfd:
+		return append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 0xfd)
+		return appendUint16(buf, uint16(n))
+	case n <= 0xffffffff:
+		buf = append(buf, 0xfe)
+		return appendUint32(buf, uint32(n))
+	default:
+		buf = append(buf, 0xff)
+		return appendUint64(buf, n)
+	}
+}
+
+// readCompactSize reads a variable length integer, refusing encodings that
+// are longer than necessary
+func readCompactSize(r io.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, err
+	}
+
+	var (
+		n     uint64
+		least uint64
+	)
+	switch b[0] {
+	case 0xfd:
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return 0, err
+		}
+		n, least = uint64(binary.LittleEndian.Uint16(b[:2])), 0xfd
+	case 0xfe:
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return 0, err
+		}
+		n, least = uint64(binary.LittleEndian.Uint32(b[:4])), 0x10000
+	case 0xff:
+		if _, err := io.ReadFull(r, b[:8]); err != nil {
+			return 0, err
+		}
+		n, least = binary.LittleEndian.Uint64(b[:8]), 0x100000000
+	default:
+		return uint64(b[0]), nil
+	}
+
+	if n < least {
+		return 0, ErrNonCanonicalCompactSize
+	}
+	return n, nil
+}
+
+// readElementCount reads the number of elements in a message and checks it
+// against the limit before anything is allocated for them
+func readElementCount(r io.Reader) (int, error) {
+	n, err := readCompactSize(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > MaxWireElements {
+		return 0, ErrTooManyElements
+	}
+	return int(n), nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
@@ -0,0 +1,123 @@
+package avalanche
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPollWireFormat(t *testing.T) {
+	invs := []Inv{{"tx", Hash{1}}, {"block", Hash{2}}}
+
+	buf := &bytes.Buffer{}
+	assertNoError(t, EncodePoll(buf, 258, invs))
+
+	// round | count | type | hash | type | hash
+	expected := "0201000000000000" + "02" +
+		"01000000" + "01" + strings.Repeat("00", 31) +
+		"02000000" + "02" + strings.Repeat("00", 31)
+	assertTrue(t, hex.EncodeToString(buf.Bytes()) == expected)
+
+	round, decoded, err := DecodePoll(bytes.NewReader(buf.Bytes()))
+	assertNoError(t, err)
+	assertTrue(t, round == 258)
+	assertTrue(t, len(decoded) == 2 && decoded[0] == invs[0] && decoded[1] == invs[1])
+
+	// Types without a wire code can't be sent or received
+	assertTrue(t, EncodePoll(&bytes.Buffer{}, 0, []Inv{{"proof", Hash{}}}) == ErrUnknownInvType)
+	unknown := append([]byte{}, buf.Bytes()...)
+	unknown[9] = 0xff
+	_, _, err = DecodePoll(bytes.NewReader(unknown))
+	assertTrue(t, err == ErrUnknownInvType)
+
+	// Truncated messages are refused
+	_, _, err = DecodePoll(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assertTrue(t, err == io.ErrUnexpectedEOF)
+
+	// So are messages with too many invs
+	tooMany := make([]Inv, MaxWireElements+1)
+	for i := range tooMany {
+		tooMany[i] = Inv{"tx", hashFromInt(i)}
+	}
+	assertTrue(t, EncodePoll(&bytes.Buffer{}, 0, tooMany) == ErrTooManyElements)
+
+	oversized := appendCompactSize(appendUint64(nil, 0), MaxWireElements+1)
+	_, _, err = DecodePoll(bytes.NewReader(oversized))
+	assertTrue(t, err == ErrTooManyElements)
+}
+
+func TestResponseWireFormat(t *testing.T) {
+	resp := NewResponse(7, 100, []Vote{NewVote(0, Hash{1}), NewVote(negativeOne, Hash{2})})
+
+	buf := &bytes.Buffer{}
+	assertNoError(t, EncodeResponse(buf, resp))
+
+	// round | cooldown | count | error | hash | error | hash
+	expected := "0700000000000000" + "64000000" + "02" +
+		"00000000" + "01" + strings.Repeat("00", 31) +
+		"ffffffff" + "02" + strings.Repeat("00", 31)
+	assertTrue(t, hex.EncodeToString(buf.Bytes()) == expected)
+
+	decoded, err := DecodeResponse(bytes.NewReader(buf.Bytes()))
+	assertNoError(t, err)
+	assertTrue(t, decoded.GetRound() == 7)
+	assertTrue(t, decoded.GetCooldown() == 100)
+	assertTrue(t, len(decoded.GetVotes()) == 2)
+	for i, v := range decoded.GetVotes() {
+		assertTrue(t, v == resp.GetVotes()[i])
+	}
+
+	_, err = DecodeResponse(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assertTrue(t, err == io.ErrUnexpectedEOF)
+
+	tooMany := NewResponse(0, 0, make([]Vote, MaxWireElements+1))
+	assertTrue(t, EncodeResponse(&bytes.Buffer{}, tooMany) == ErrTooManyElements)
+	_, err = tooMany.Sign(make(ed25519.PrivateKey, ed25519.PrivateKeySize))
+	assertTrue(t, err == ErrTooManyElements)
+
+	// Signed responses carry their signature after the response
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assertNoError(t, err)
+	assertTrue(t, EncodeSignedResponse(&bytes.Buffer{}, resp) == ErrInvalidSignature)
+
+	signed, err := resp.Sign(priv)
+	assertNoError(t, err)
+	buf.Reset()
+	assertNoError(t, EncodeSignedResponse(buf, signed))
+	assertTrue(t, buf.Len() == len(expected)/2+ed25519.SignatureSize)
+
+	decoded, err = DecodeSignedResponse(bytes.NewReader(buf.Bytes()))
+	assertNoError(t, err)
+	assertTrue(t, decoded.Verify(pub))
+	assertTrue(t, bytes.Equal(decoded.GetSignature(), signed.GetSignature()))
+}
+
+func TestCompactSize(t *testing.T) {
+	tests := map[uint64]string{
+		0:           "00",
+		0xfc:        "fc",
+		0xfd:        "fdfd00",
+		0xffff:      "fdffff",
+		0x10000:     "fe00000100",
+		0xffffffff:  "feffffffff",
+		0x100000000: "ff0000000001000000",
+	}
+	for n, expected := range tests {
+		buf := appendCompactSize(nil, n)
+		assertTrue(t, hex.EncodeToString(buf) == expected)
+
+		decoded, err := readCompactSize(bytes.NewReader(buf))
+		assertNoError(t, err)
+		assertTrue(t, decoded == n)
+	}
+
+	// Values must use the shortest encoding
+	for _, encoded := range []string{"fdfc00", "feffff0000", "ffffffffff00000000"} {
+		b, _ := hex.DecodeString(encoded)
+		_, err := readCompactSize(bytes.NewReader(b))
+		assertTrue(t, err == ErrNonCanonicalCompactSize)
+	}
+}